@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"kaffino/internal/config"
+	"kaffino/internal/logging"
 	"kaffino/internal/server"
 )
 
@@ -20,25 +24,47 @@ func gracefulShutdown(apiServer *http.Server, done chan bool) {
 	// Listen for the interrupt signal.
 	<-ctx.Done()
 
-	log.Println("shutting down gracefully, press Ctrl+C again to force")
+	slog.Info("shutting down gracefully, press Ctrl+C again to force")
 
 	// The context is used to inform the server it has 5 seconds to finish
 	// the request it is currently handling
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := apiServer.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown with error: %v", err)
+		slog.Error("Server forced to shutdown", "error", err)
 	}
 
-	log.Println("Server exiting")
+	slog.Info("Server exiting")
 
 	// Notify the main goroutine that the shutdown is complete
 	done <- true
 }
 
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Log JSON lines, including what is still logged through the log package
+	slog.SetDefault(logging.New(os.Stderr, cfg.LogLevel))
+
+	if len(cfg.Args) > 0 {
+		if cfg.Args[0] != "migrate" {
+			log.Fatalf("unknown command %q", cfg.Args[0])
+		}
+		if err := runMigrate(cfg, cfg.Args[1:]); err != nil {
+			slog.Error("Migration failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	server := server.NewServer()
+	server, err := server.NewServer(cfg)
+	if err != nil {
+		slog.Error("Failed to start server", "error", err)
+		os.Exit(1)
+	}
 
 	// Create a done channel to signal when the shutdown is complete
 	done := make(chan bool, 1)
@@ -46,14 +72,13 @@ func main() {
 	// Run graceful shutdown in a separate goroutine
 	go gracefulShutdown(server, done)
 
-	err := server.ListenAndServe()
+	slog.Info("Server starting", "port", cfg.Port, "env", cfg.Env)
+	err = server.ListenAndServe()
 	if err != nil && err != http.ErrServerClosed {
 		panic(fmt.Sprintf("http server error: %s", err))
 	}
 
-	log.Println("Server started at port 8080")
-
 	// Wait for the graceful shutdown to complete
 	<-done
-	log.Println("Graceful shutdown complete.")
+	slog.Info("Graceful shutdown complete")
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"kaffino/internal/config"
+	"kaffino/internal/database"
+)
+
+const migrateUsage = `usage: main [flags] migrate <command>
+
+commands:
+  up          apply every pending migration
+  down [n]    roll back the last n migrations (default 1)
+  status      list migrations and whether they are applied`
+
+// runMigrate runs the migrate command with its arguments.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command\n%s", migrateUsage)
+	}
+
+	ctx := context.Background()
+	db := database.NewDB(cfg.DatabaseURL)
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		n, err := db.MigrateUp(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		n, err := db.MigrateDown(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back %d migrations\n", n)
+	case "status":
+		statuses, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, m := range statuses {
+			appliedAt := "pending"
+			if m.Applied {
+				appliedAt = m.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", m.Version, m.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+	return nil
+}
@@ -2,6 +2,13 @@ import React, { useState, useEffect } from "react";
 import { useParams } from "react-router-dom";
 import { Cart } from "./Cart";
 
+interface Review {
+  id: string;
+  rating: number;
+  comment: string;
+  created_at: string;
+}
+
 interface Product {
   id: string;
   code: string;
@@ -10,7 +17,9 @@ interface Product {
   title: string;
   description: string;
   long_description: string;
-  reviews: string[];
+  rating: number;
+  review_count: number;
+  reviews: Review[];
   map_size_price: { [key: string]: number };
   shedules: string[];
   tags: string[];
@@ -100,7 +109,19 @@ export const ProductDetail: React.FC<ProductDetailProps> = ({ addToCart }) => {
               <h1 className="text-2xl font-bold text-licorice mb-2">
                 {product.title}
               </h1>
+              {product.review_count > 0 && (
+                <p className="text-sm text-gray-600 mb-2">
+                  {"★".repeat(Math.round(product.rating))} {product.rating.toFixed(1)} ({product.review_count} reviews)
+                </p>
+              )}
               <p className="text-gray-700">{product.long_description}</p>
+              <div className="flex flex-wrap gap-2 my-2">
+                {product.tags?.map((tag) => (
+                  <span key={tag} className="text-xs bg-gray-200 text-licorice rounded-full px-2 py-1">
+                    {tag}
+                  </span>
+                ))}
+              </div>
               <div className="mb-4">
                 <label className="block text-sm font-bold mb-2">Purchase Options</label>
                 <div className="grid grid-cols-1 gap-4">
@@ -1,14 +1,23 @@
 import React, { useState, useEffect } from "react";
 
+interface Review {
+  id: string;
+  rating: number;
+  comment: string;
+  created_at: string;
+}
+
 interface Product {
   id: string;
   code: string;
-  images: string;
+  images: string[];
   discount: number;
   title: string;
   description: string;
   long_description: string;
-  reviews: string[];
+  rating: number;
+  review_count: number;
+  reviews: Review[];
   map_size_price: { [key: string]: number };
   shedules: string[];
   tags: string[];
@@ -29,7 +38,7 @@ export const Products: React.FC = () => {
           throw new Error(`HTTP error! status: ${response.status}`);
         }
         const data = await response.json();
-        setProducts(data);
+        setProducts(data.products);
       } catch (error) {
         console.error("Could not fetch products:", error);
       }
@@ -53,7 +62,7 @@ export const Products: React.FC = () => {
               <img
                 alt={`Product image with placeholder text '${product.title}'`}
                 className="w-full h-64 object-cover"
-                src={product.images}
+                src={product.images[0]}
               />
               <div className="p-4">
                 <h3 className="text-xl font-semibold text-sepia mb-2">
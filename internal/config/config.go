@@ -0,0 +1,438 @@
+// Package config loads the settings of the backend. Settings come from, in
+// increasing order of precedence: built-in defaults, an optional JSON config
+// file, environment variables and command line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "github.com/joho/godotenv/autoload"
+)
+
+// Environments the backend can run in.
+const (
+	EnvDevelopment = "development"
+	EnvStaging     = "staging"
+	EnvProduction  = "production"
+)
+
+// Email backends.
+const (
+	EmailBackendSES    = "ses"
+	EmailBackendSMTP   = "smtp"
+	EmailBackendFile   = "file"
+	EmailBackendStdout = "stdout"
+)
+
+// Payment providers.
+const (
+	PaymentProviderFake = "fake"
+)
+
+// DefaultSessionKey is the session key used in development when none is
+// set. It is public, so the backend refuses to use it in production.
+const DefaultSessionKey = "super-secret-key"
+
+// DefaultWebhookSecret signs payment webhooks in development when no secret
+// is set. Like DefaultSessionKey it is refused in production.
+const DefaultWebhookSecret = "dev-webhook-secret"
+
+// Config holds every setting of the backend.
+type Config struct {
+	Env         string `json:"env"`
+	Port        int    `json:"port"`
+	DatabaseURL string `json:"database_url"`
+	// AutoMigrate applies pending schema migrations on startup. Turn it off
+	// to run them with the migrate command instead.
+	AutoMigrate bool `json:"auto_migrate"`
+	// LogLevel is the lowest level logged: debug, info, warn or error.
+	LogLevel slog.Level `json:"log_level"`
+
+	// SessionKey signs session cookies, login codes and login links.
+	SessionKey string `json:"session_key"`
+	// SecureCookies marks the session cookie as HTTPS only. It defaults to
+	// true outside development.
+	SecureCookies *bool `json:"secure_cookies"`
+	// TrustedProxies are the addresses or CIDR ranges of the reverse
+	// proxies in front of the server. Only requests coming from them may
+	// name the client address in X-Real-IP.
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// AdminEmails are made admins on startup.
+	AdminEmails []string `json:"admin_emails"`
+	// ReviewsRequirePurchase only lets customers review products they
+	// have received.
+	ReviewsRequirePurchase bool `json:"reviews_require_purchase"`
+	// LoginLinkURL is the public base URL of the API, such as
+	// https://kaffino.pe/api/v1. When set, login emails also carry a
+	// one-click login link.
+	LoginLinkURL string `json:"login_link_url"`
+	// MetricsToken must be sent as a bearer token to scrape /metrics. It is
+	// required outside development.
+	MetricsToken string `json:"metrics_token"`
+	// ShippingFee is charged on every order, in soles, unless a free
+	// shipping discount code waives it.
+	ShippingFee float64 `json:"shipping_fee"`
+
+	Email    Email    `json:"email"`
+	Payments Payments `json:"payments"`
+	Tax      Tax      `json:"tax"`
+
+	// Args are the command line arguments left after the flags, such as
+	// the migrate command.
+	Args []string `json:"-"`
+}
+
+// Email configures how login emails are delivered.
+type Email struct {
+	Backend string `json:"backend"`
+	From    string `json:"from"`
+
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"smtp_password"`
+
+	// Dir is where the file backend writes messages.
+	Dir string `json:"dir"`
+}
+
+// Payments configures the payment provider and its webhooks.
+type Payments struct {
+	Provider string `json:"provider"`
+	// WebhookSecret is shared with the provider to sign webhooks.
+	WebhookSecret string `json:"webhook_secret"`
+	// WebhookURL is where the fake provider delivers its webhooks. It
+	// defaults to this server's /webhooks/payments.
+	WebhookURL string `json:"webhook_url"`
+	// AllowFake lets production run with the fake provider, which charges
+	// nobody.
+	AllowFake bool `json:"allow_fake"`
+}
+
+// Tax configures the sales tax charged on orders.
+type Tax struct {
+	// Rate is the IGV rate, 0.18 by default.
+	Rate float64 `json:"rate"`
+	// PricesIncludeTax is true when catalog prices and the shipping fee
+	// already include IGV, so tax is carved out of them rather than added.
+	PricesIncludeTax bool `json:"prices_include_tax"`
+}
+
+// Default returns the configuration used when nothing else is set.
+func Default() *Config {
+	return &Config{
+		Env:         EnvDevelopment,
+		Port:        8080,
+		AutoMigrate: true,
+		Email: Email{
+			Backend:  EmailBackendSES,
+			From:     "no-reply@sessioninit-kafff.jota-fab.com",
+			SMTPPort: 587,
+		},
+		Payments: Payments{
+			Provider: PaymentProviderFake,
+		},
+		Tax: Tax{
+			Rate:             0.18,
+			PricesIncludeTax: true,
+		},
+	}
+}
+
+// Load builds the configuration from the config file, the environment and
+// the command line arguments, and validates it. The config file is named by
+// the -config flag or the KAFFINO_CONFIG variable.
+func Load(args []string) (*Config, error) {
+	return load(args, os.LookupEnv)
+}
+
+func load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	var (
+		flags      = flag.NewFlagSet("kaffino", flag.ContinueOnError)
+		configFile = flags.String("config", "", "path to a JSON config file")
+		env        = flags.String("env", "", "environment: development, staging or production")
+		port       = flags.Int("port", 0, "port to listen on")
+		dbURL      = flags.String("db", "", "SQLite database path")
+		emailMode  = flags.String("email-backend", "", "email backend: ses, smtp, file or stdout")
+	)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	cfg.Args = flags.Args()
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv("KAFFINO_CONFIG")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+
+	// Only flags given on the command line override the other sources
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "env":
+			cfg.Env = *env
+		case "port":
+			cfg.Port = *port
+		case "db":
+			cfg.DatabaseURL = *dbURL
+		case "email-backend":
+			cfg.Email.Backend = *emailMode
+		}
+	})
+
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv(lookupEnv func(string) (string, bool)) error {
+	str := func(key string, dst *string) {
+		if v, ok := lookupEnv(key); ok && v != "" {
+			*dst = v
+		}
+	}
+	var errs []error
+	integer := func(key string, dst *int) {
+		if v, ok := lookupEnv(key); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a number: %q", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	decimal := func(key string, dst *float64) {
+		if v, ok := lookupEnv(key); ok && v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a number: %q", key, v))
+				return
+			}
+			*dst = f
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if v, ok := lookupEnv(key); ok && v != "" {
+			*dst = nil
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v, ok := lookupEnv(key); ok && v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be true or false: %q", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	str("APP_ENV", &c.Env)
+	integer("PORT", &c.Port)
+	str("BLUEPRINT_DB_URL", &c.DatabaseURL)
+	boolean("AUTO_MIGRATE", &c.AutoMigrate)
+	if v, ok := lookupEnv("LOG_LEVEL"); ok && v != "" {
+		if err := c.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			errs = append(errs, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error: %q", v))
+		}
+	}
+	str("SESSION_KEY", &c.SessionKey)
+	if v, ok := lookupEnv("COOKIE_SECURE"); ok && v != "" {
+		secure := false
+		boolean("COOKIE_SECURE", &secure)
+		c.SecureCookies = &secure
+	}
+	list("TRUSTED_PROXIES", &c.TrustedProxies)
+	list("ADMIN_EMAILS", &c.AdminEmails)
+	boolean("REVIEWS_REQUIRE_PURCHASE", &c.ReviewsRequirePurchase)
+	str("LOGIN_LINK_URL", &c.LoginLinkURL)
+	str("METRICS_TOKEN", &c.MetricsToken)
+	decimal("SHIPPING_FEE", &c.ShippingFee)
+
+	str("EMAIL_BACKEND", &c.Email.Backend)
+	str("EMAIL_FROM", &c.Email.From)
+	str("SMTP_HOST", &c.Email.SMTPHost)
+	integer("SMTP_PORT", &c.Email.SMTPPort)
+	str("SMTP_USERNAME", &c.Email.SMTPUsername)
+	str("SMTP_PASSWORD", &c.Email.SMTPPassword)
+	str("EMAIL_DIR", &c.Email.Dir)
+
+	str("PAYMENT_PROVIDER", &c.Payments.Provider)
+	str("PAYMENT_WEBHOOK_SECRET", &c.Payments.WebhookSecret)
+	str("PAYMENT_WEBHOOK_URL", &c.Payments.WebhookURL)
+	boolean("PAYMENT_ALLOW_FAKE", &c.Payments.AllowFake)
+
+	decimal("IGV_RATE", &c.Tax.Rate)
+	boolean("PRICES_INCLUDE_IGV", &c.Tax.PricesIncludeTax)
+
+	return errors.Join(errs...)
+}
+
+// applyDefaults fills in the settings whose default depends on others.
+func (c *Config) applyDefaults() {
+	if c.SessionKey == "" && c.Env == EnvDevelopment {
+		c.SessionKey = DefaultSessionKey
+	}
+	if c.Payments.WebhookSecret == "" && c.Env == EnvDevelopment {
+		c.Payments.WebhookSecret = DefaultWebhookSecret
+	}
+	if c.Payments.WebhookURL == "" {
+		c.Payments.WebhookURL = fmt.Sprintf("http://localhost:%d/webhooks/payments", c.Port)
+	}
+	if c.SecureCookies == nil {
+		secure := c.Env != EnvDevelopment
+		c.SecureCookies = &secure
+	}
+}
+
+// Validate checks that the configuration is complete and safe to run with.
+func (c *Config) Validate() error {
+	var errs []error
+
+	switch c.Env {
+	case EnvDevelopment, EnvStaging, EnvProduction:
+	default:
+		errs = append(errs, fmt.Errorf("unknown environment %q", c.Env))
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid port %d", c.Port))
+	}
+	if c.DatabaseURL == "" {
+		errs = append(errs, errors.New("a database is required, set BLUEPRINT_DB_URL or -db"))
+	}
+
+	if c.SessionKey == "" {
+		errs = append(errs, errors.New("SESSION_KEY is required outside development"))
+	} else if c.Env == EnvProduction && c.SessionKey == DefaultSessionKey {
+		errs = append(errs, errors.New("refusing to run in production with the default session key, set SESSION_KEY"))
+	}
+
+	if c.MetricsToken == "" && c.Env != EnvDevelopment {
+		errs = append(errs, errors.New("METRICS_TOKEN is required outside development"))
+	}
+
+	for _, proxy := range c.TrustedProxies {
+		if _, err := parseProxy(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("TRUSTED_PROXIES must be IP addresses or CIDR ranges: %q", proxy))
+		}
+	}
+
+	if c.LoginLinkURL != "" {
+		u, err := url.Parse(c.LoginLinkURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("LOGIN_LINK_URL must be an absolute URL: %q", c.LoginLinkURL))
+		}
+	}
+
+	if c.ShippingFee < 0 {
+		errs = append(errs, fmt.Errorf("SHIPPING_FEE cannot be negative: %v", c.ShippingFee))
+	}
+	if c.Tax.Rate < 0 || c.Tax.Rate >= 1 {
+		errs = append(errs, fmt.Errorf("IGV_RATE must be a fraction such as 0.18: %v", c.Tax.Rate))
+	}
+
+	switch c.Email.Backend {
+	case EmailBackendSES, EmailBackendStdout:
+	case EmailBackendSMTP:
+		if c.Email.SMTPHost == "" {
+			errs = append(errs, errors.New("the smtp email backend needs SMTP_HOST"))
+		}
+	case EmailBackendFile:
+		if c.Email.Dir == "" {
+			errs = append(errs, errors.New("the file email backend needs EMAIL_DIR"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown email backend %q", c.Email.Backend))
+	}
+	if c.Email.From == "" {
+		errs = append(errs, errors.New("EMAIL_FROM is required"))
+	}
+
+	switch c.Payments.Provider {
+	case PaymentProviderFake:
+		if c.Env == EnvProduction && !c.Payments.AllowFake {
+			errs = append(errs, errors.New("the fake payment provider charges nobody, set PAYMENT_ALLOW_FAKE=true to run it in production"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown payment provider %q", c.Payments.Provider))
+	}
+	if c.Payments.WebhookSecret == "" {
+		errs = append(errs, errors.New("PAYMENT_WEBHOOK_SECRET is required outside development"))
+	} else if c.Env == EnvProduction && c.Payments.WebhookSecret == DefaultWebhookSecret {
+		errs = append(errs, errors.New("refusing to run in production with the default webhook secret, set PAYMENT_WEBHOOK_SECRET"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// TrustedProxyRanges returns the trusted proxies as address ranges, a single
+// address being a range of one. Invalid entries are left out; Validate
+// reports them.
+func (c *Config) TrustedProxyRanges() []netip.Prefix {
+	ranges := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if prefix, err := parseProxy(proxy); err == nil {
+			ranges = append(ranges, prefix)
+		}
+	}
+	return ranges
+}
+
+func parseProxy(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Secure reports whether session cookies are HTTPS only.
+func (c *Config) Secure() bool {
+	return c.SecureCookies != nil && *c.SecureCookies
+}
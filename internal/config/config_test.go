@@ -0,0 +1,139 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func envOf(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "kaffino.json")
+	err := os.WriteFile(file, []byte(`{"port": 9000, "database_url": "file.db", "email": {"backend": "stdout"}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := load([]string{"-config", file}, envOf(nil))
+	if err != nil {
+		t.Fatalf("error loading config. Err: %v", err)
+	}
+	if cfg.Port != 9000 || cfg.DatabaseURL != "file.db" || cfg.Email.Backend != EmailBackendStdout {
+		t.Errorf("expected the file settings; got %+v", cfg)
+	}
+	if cfg.SessionKey != DefaultSessionKey || cfg.Secure() {
+		t.Errorf("expected development defaults; got %+v", cfg)
+	}
+
+	env := envOf(map[string]string{
+		"KAFFINO_CONFIG":   file,
+		"PORT":             "9001",
+		"BLUEPRINT_DB_URL": "env.db",
+		"ADMIN_EMAILS":     "ana@example.com, ,bob@example.com",
+	})
+	cfg, err = load([]string{"-port", "9002"}, env)
+	if err != nil {
+		t.Fatalf("error loading config. Err: %v", err)
+	}
+	if cfg.Port != 9002 {
+		t.Errorf("expected the flag to win; got port %d", cfg.Port)
+	}
+	if cfg.DatabaseURL != "env.db" {
+		t.Errorf("expected the environment to beat the file; got %s", cfg.DatabaseURL)
+	}
+	if strings.Join(cfg.AdminEmails, ",") != "ana@example.com,bob@example.com" {
+		t.Errorf("unexpected admin emails %v", cfg.AdminEmails)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	base := map[string]string{"BLUEPRINT_DB_URL": "kaffino.db"}
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"production default key", map[string]string{"APP_ENV": "production", "SESSION_KEY": DefaultSessionKey}, "default session key"},
+		{"production without webhook secret", map[string]string{"APP_ENV": "production", "SESSION_KEY": "a-long-random-key"}, "PAYMENT_WEBHOOK_SECRET is required"},
+		{"production with the fake provider", map[string]string{"APP_ENV": "production"}, "fake payment provider"},
+		{"unknown payment provider", map[string]string{"PAYMENT_PROVIDER": "cash"}, "unknown payment provider"},
+		{"production without key", map[string]string{"APP_ENV": "production"}, "SESSION_KEY is required"},
+		{"staging without metrics token", map[string]string{"APP_ENV": "staging", "SESSION_KEY": "a-long-random-key"}, "METRICS_TOKEN is required"},
+		{"unknown environment", map[string]string{"APP_ENV": "prod"}, "unknown environment"},
+		{"bad log level", map[string]string{"LOG_LEVEL": "loud"}, "LOG_LEVEL"},
+		{"bad port", map[string]string{"PORT": "eighty"}, "PORT must be a number"},
+		{"port out of range", map[string]string{"PORT": "70000"}, "invalid port"},
+		{"relative login link", map[string]string{"LOGIN_LINK_URL": "/api/v1"}, "LOGIN_LINK_URL"},
+		{"smtp without host", map[string]string{"EMAIL_BACKEND": "smtp"}, "SMTP_HOST"},
+		{"no database", map[string]string{"BLUEPRINT_DB_URL": ""}, "database is required"},
+		{"bad shipping fee", map[string]string{"SHIPPING_FEE": "free"}, "SHIPPING_FEE must be a number"},
+		{"negative shipping fee", map[string]string{"SHIPPING_FEE": "-5"}, "SHIPPING_FEE cannot be negative"},
+		{"tax rate as a percentage", map[string]string{"IGV_RATE": "18"}, "IGV_RATE must be a fraction"},
+		{"bad trusted proxy", map[string]string{"TRUSTED_PROXIES": "10.0.0.1, nginx"}, "TRUSTED_PROXIES"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := map[string]string{}
+			for k, v := range base {
+				vars[k] = v
+			}
+			for k, v := range tt.env {
+				vars[k] = v
+			}
+			_, err := load(nil, envOf(vars))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q; got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	cfg, err := load(nil, envOf(map[string]string{
+		"APP_ENV":                "staging",
+		"SESSION_KEY":            "a-long-random-key",
+		"PAYMENT_WEBHOOK_SECRET": "another-long-random-key",
+		"METRICS_TOKEN":          "a-scrape-token",
+		"BLUEPRINT_DB_URL":       "kaffino.db",
+	}))
+	if err != nil {
+		t.Fatalf("expected a valid staging config; got %v", err)
+	}
+	if !cfg.Secure() {
+		t.Error("expected secure cookies in staging")
+	}
+
+	_, err = load(nil, envOf(map[string]string{
+		"APP_ENV":                "production",
+		"SESSION_KEY":            "a-long-random-key",
+		"PAYMENT_WEBHOOK_SECRET": "another-long-random-key",
+		"PAYMENT_ALLOW_FAKE":     "true",
+		"METRICS_TOKEN":          "a-scrape-token",
+		"BLUEPRINT_DB_URL":       "kaffino.db",
+	}))
+	if err != nil {
+		t.Errorf("expected production to run the fake provider when allowed; got %v", err)
+	}
+}
+
+func TestTrustedProxyRanges(t *testing.T) {
+	cfg, err := load(nil, envOf(map[string]string{
+		"BLUEPRINT_DB_URL": "kaffino.db",
+		"TRUSTED_PROXIES":  "172.18.0.1/16, 10.0.0.2,::1",
+	}))
+	if err != nil {
+		t.Fatalf("error loading config. Err: %v", err)
+	}
+	var got []string
+	for _, r := range cfg.TrustedProxyRanges() {
+		got = append(got, r.String())
+	}
+	if want := "172.18.0.0/16,10.0.0.2/32,::1/128"; strings.Join(got, ",") != want {
+		t.Errorf("expected trusted proxies %s; got %v", want, got)
+	}
+}
@@ -0,0 +1,292 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrCartItemNotFound is returned when a cart item does not exist in the user's cart.
+	ErrCartItemNotFound = errors.New("cart item not found")
+	// ErrInvalidCartItem is returned when a cart item cannot be added as sent.
+	ErrInvalidCartItem = errors.New("invalid cart item")
+)
+
+// Cart is a user's shopping cart, kept on the server.
+type Cart struct {
+	ID        string
+	UserID    string
+	CreatedAt sql.NullTime
+	UpdatedAt sql.NullTime
+}
+
+// CartItem is a product size and schedule in a cart.
+type CartItem struct {
+	ID        string
+	CartID    string
+	ProductID string
+	Size      string
+	Schedule  string
+	Quantity  int64
+	CreatedAt sql.NullTime
+	UpdatedAt sql.NullTime
+}
+
+// CartItemInput is an item a user wants to put in their cart.
+type CartItemInput struct {
+	ProductID string
+	Size      string
+	Schedule  string
+	Quantity  int64
+}
+
+// CartLine is a cart item together with its current unit price.
+type CartLine struct {
+	CartItem
+	Price float64
+}
+
+// CartDetails is a cart together with its items. A user without a cart gets
+// an empty CartDetails with no ID.
+type CartDetails struct {
+	Cart
+	Items []CartLine
+}
+
+// GetCart retrieves the cart of a session user, guest or not.
+func (s *service) GetCart(ctx context.Context, userID string) (*CartDetails, error) {
+	cart := &CartDetails{Cart: Cart{UserID: userID}}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, created_at, updated_at
+		FROM carts
+		WHERE user_id = ?
+	`, userID).Scan(&cart.ID, &cart.UserID, &cart.CreatedAt, &cart.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return cart, nil
+		}
+		return nil, fmt.Errorf("error getting cart: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT ci.id, ci.cart_id, ci.product_id, ci.size, ci.schedule, ci.quantity, ci.created_at, ci.updated_at,
+			COALESCE(
+				(SELECT price FROM inventory WHERE product_id = ci.product_id AND sizes = ci.size),
+				(SELECT price FROM inventory WHERE product_id = ci.product_id AND (ci.size = '' OR sizes IS NULL OR sizes = '') ORDER BY created_at LIMIT 1),
+				0)
+		FROM cart_items ci
+		WHERE ci.cart_id = ?
+		ORDER BY ci.created_at, ci.rowid
+	`, cart.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing cart items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var line CartLine
+		err := rows.Scan(&line.ID, &line.CartID, &line.ProductID, &line.Size, &line.Schedule, &line.Quantity,
+			&line.CreatedAt, &line.UpdatedAt, &line.Price)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning cart item: %w", err)
+		}
+		cart.Items = append(cart.Items, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating cart items: %w", err)
+	}
+
+	return cart, nil
+}
+
+// AddCartItem adds an item to the user's cart, creating the cart if needed.
+// Adding a product that is already in the cart with the same size and
+// schedule increases its quantity.
+func (s *service) AddCartItem(ctx context.Context, userID string, item CartItemInput) (*CartDetails, error) {
+	if item.ProductID == "" || item.Quantity <= 0 {
+		return nil, fmt.Errorf("%w: a product and a positive quantity are required", ErrInvalidCartItem)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := findVariant(ctx, tx, item.ProductID, item.Size, ErrInvalidCartItem); err != nil {
+		return nil, err
+	}
+
+	cartID, err := ensureCart(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := upsertCartItem(ctx, tx, cartID, item); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing cart: %w", err)
+	}
+
+	return s.GetCart(ctx, userID)
+}
+
+// UpdateCartItem sets the quantity of an item in the user's cart.
+// A quantity of zero or less removes the item.
+func (s *service) UpdateCartItem(ctx context.Context, userID, itemID string, quantity int64) (*CartDetails, error) {
+	if quantity <= 0 {
+		return s.RemoveCartItem(ctx, userID, itemID)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE cart_items
+		SET quantity = ?, updated_at = ?
+		WHERE id = ? AND cart_id = (SELECT id FROM carts WHERE user_id = ?)
+	`, quantity, time.Now(), itemID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating cart item: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrCartItemNotFound
+	}
+
+	return s.GetCart(ctx, userID)
+}
+
+// RemoveCartItem removes an item from the user's cart.
+func (s *service) RemoveCartItem(ctx context.Context, userID, itemID string) (*CartDetails, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM cart_items
+		WHERE id = ? AND cart_id = (SELECT id FROM carts WHERE user_id = ?)
+	`, itemID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error removing cart item: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrCartItemNotFound
+	}
+
+	return s.GetCart(ctx, userID)
+}
+
+// ClearCart removes every item from the user's cart.
+func (s *service) ClearCart(ctx context.Context, userID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM cart_items
+		WHERE cart_id = (SELECT id FROM carts WHERE user_id = ?)
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("error clearing cart: %w", err)
+	}
+	return nil
+}
+
+// MergeCarts moves the items of one user's cart into another's. It is used
+// when a guest logs in, so the guest cart follows them into their account.
+func (s *service) MergeCarts(ctx context.Context, fromUserID, toUserID string) error {
+	if fromUserID == toUserID {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var fromCartID string
+	err = tx.QueryRowContext(ctx, `SELECT id FROM carts WHERE user_id = ?`, fromUserID).Scan(&fromCartID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("error getting cart: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT product_id, size, schedule, quantity
+		FROM cart_items
+		WHERE cart_id = ?
+	`, fromCartID)
+	if err != nil {
+		return fmt.Errorf("error listing cart items: %w", err)
+	}
+	var items []CartItemInput
+	for rows.Next() {
+		var item CartItemInput
+		if err := rows.Scan(&item.ProductID, &item.Size, &item.Schedule, &item.Quantity); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning cart item: %w", err)
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating cart items: %w", err)
+	}
+
+	if len(items) > 0 {
+		toCartID, err := ensureCart(ctx, tx, toUserID)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := upsertCartItem(ctx, tx, toCartID, item); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM cart_items WHERE cart_id = ?`, fromCartID); err != nil {
+		return fmt.Errorf("error deleting cart items: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM carts WHERE id = ?`, fromCartID); err != nil {
+		return fmt.Errorf("error deleting cart: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing cart merge: %w", err)
+	}
+	return nil
+}
+
+// ensureCart returns the ID of the user's cart, creating it if it does not exist.
+func ensureCart(ctx context.Context, db DBTX, userID string) (string, error) {
+	now := time.Now()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO carts (id, user_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET updated_at = excluded.updated_at
+	`, uuid.New().String(), userID, now, now)
+	if err != nil {
+		return "", fmt.Errorf("error creating cart: %w", err)
+	}
+
+	var cartID string
+	if err := db.QueryRowContext(ctx, `SELECT id FROM carts WHERE user_id = ?`, userID).Scan(&cartID); err != nil {
+		return "", fmt.Errorf("error getting cart: %w", err)
+	}
+	return cartID, nil
+}
+
+// upsertCartItem inserts an item or adds to the quantity of a matching one.
+func upsertCartItem(ctx context.Context, db DBTX, cartID string, item CartItemInput) error {
+	now := time.Now()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO cart_items (id, cart_id, product_id, size, schedule, quantity, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (cart_id, product_id, size, schedule)
+		DO UPDATE SET quantity = quantity + excluded.quantity, updated_at = excluded.updated_at
+	`, uuid.New().String(), cartID, item.ProductID, item.Size, item.Schedule, item.Quantity, now, now)
+	if err != nil {
+		return fmt.Errorf("error adding cart item: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Login challenges are the one-time codes emailed to users who log in, and
+// login links are the one-click links sent along with them. Only hashes of
+// the codes and link tokens are stored. Failed attempts are counted per email so a
+// code cannot be guessed, and the counters live in the database so every
+// backend replica sees the same lockouts.
+
+// SaveLoginChallenge stores the hash of a new login code for an email,
+// replacing any earlier code.
+func (s *service) SaveLoginChallenge(ctx context.Context, email, codeHash string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO login_challenges (email, code_hash, expires_at, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (email) DO UPDATE
+		SET code_hash = excluded.code_hash, expires_at = excluded.expires_at, created_at = excluded.created_at
+	`, email, codeHash, expiresAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error saving login challenge: %w", err)
+	}
+	return nil
+}
+
+// ConsumeLoginChallenge checks a code hash against the stored challenge of
+// an email. A matching, unexpired challenge is deleted in the same statement,
+// so each code logs in once even with concurrent requests.
+func (s *service) ConsumeLoginChallenge(ctx context.Context, email, codeHash string, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM login_challenges
+		WHERE email = ? AND code_hash = ? AND expires_at > ?
+	`, email, codeHash, now.UTC())
+	if err != nil {
+		return false, fmt.Errorf("error consuming login challenge: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error consuming login challenge: %w", err)
+	}
+	return n == 1, nil
+}
+
+// LoginLockedUntil returns when the lockout of an email ends. It returns the
+// zero time when the email is not locked out.
+func (s *service) LoginLockedUntil(ctx context.Context, email string, now time.Time) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT locked_until FROM login_attempts WHERE email = ?
+	`, email).Scan(&lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("error getting login attempts: %w", err)
+	}
+	if !lockedUntil.Valid || !lockedUntil.Time.After(now) {
+		return time.Time{}, nil
+	}
+	return lockedUntil.Time, nil
+}
+
+// RecordFailedLogin counts a failed login for an email. Once maxAttempts
+// failures pile up the email is locked out for the given duration and the
+// count starts over. It returns when the lockout ends, or the zero time when
+// the email is not locked out.
+func (s *service) RecordFailedLogin(ctx context.Context, email string, now time.Time, maxAttempts int, lockout time.Duration) (time.Time, error) {
+	var attempts int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO login_attempts (email, attempts, last_attempt)
+		VALUES (?, 1, ?)
+		ON CONFLICT (email) DO UPDATE
+		SET attempts = attempts + 1, last_attempt = excluded.last_attempt
+		RETURNING attempts
+	`, email, now.UTC()).Scan(&attempts)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error recording failed login: %w", err)
+	}
+	if attempts < maxAttempts {
+		return time.Time{}, nil
+	}
+
+	lockedUntil := now.Add(lockout)
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE login_attempts
+		SET attempts = 0, locked_until = ?
+		WHERE email = ?
+	`, lockedUntil.UTC(), email)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error locking login: %w", err)
+	}
+	// The challenge is burned so the next try needs a fresh code
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM login_challenges WHERE email = ?`, email); err != nil {
+		return time.Time{}, fmt.Errorf("error deleting login challenge: %w", err)
+	}
+	return lockedUntil, nil
+}
+
+// ResetFailedLogins clears the failed login count of an email.
+func (s *service) ResetFailedLogins(ctx context.Context, email string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE email = ?`, email); err != nil {
+		return fmt.Errorf("error resetting login attempts: %w", err)
+	}
+	return nil
+}
+
+// SaveLoginLink stores the hash of a login link token for an email.
+func (s *service) SaveLoginLink(ctx context.Context, tokenHash, email string, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO login_links (token_hash, email, expires_at, created_at)
+		VALUES (?, ?, ?, ?)
+	`, tokenHash, email, expiresAt.UTC(), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error saving login link: %w", err)
+	}
+	return nil
+}
+
+// ConsumeLoginLink looks up an unexpired login link and deletes it in the
+// same statement, so each link logs in once. It returns the email the link
+// was sent to and whether the link was found.
+func (s *service) ConsumeLoginLink(ctx context.Context, tokenHash string, now time.Time) (string, bool, error) {
+	var email string
+	err := s.db.QueryRowContext(ctx, `
+		DELETE FROM login_links
+		WHERE token_hash = ? AND expires_at > ?
+		RETURNING email
+	`, tokenHash, now.UTC()).Scan(&email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("error consuming login link: %w", err)
+	}
+	return email, true, nil
+}
+
+// DeleteExpiredLoginChallenges removes the challenges and login links that
+// expired before the given time. It returns how many were removed.
+func (s *service) DeleteExpiredLoginChallenges(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	for _, query := range []string{
+		`DELETE FROM login_challenges WHERE expires_at <= ?`,
+		`DELETE FROM login_links WHERE expires_at <= ?`,
+	} {
+		res, err := s.db.ExecContext(ctx, query, before.UTC())
+		if err != nil {
+			return deleted, fmt.Errorf("error deleting expired login challenges: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return deleted, fmt.Errorf("error deleting expired login challenges: %w", err)
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// DeleteStaleLoginAttempts removes the failed login counters that have not
+// changed since the given time and are not locked out.
+func (s *service) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM login_attempts
+		WHERE last_attempt <= ? AND (locked_until IS NULL OR locked_until <= ?)
+	`, before.UTC(), before.UTC())
+	if err != nil {
+		return fmt.Errorf("error deleting stale login attempts: %w", err)
+	}
+	return nil
+}
@@ -5,14 +5,13 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
+	"log/slog"
 	"strconv"
+	"strings"
 	"time"
-_ "modernc.org/sqlite"
-	_ "github.com/joho/godotenv/autoload"
-	_ "github.com/mattn/go-sqlite3"
 
-	_ "embed"
+	_ "github.com/joho/godotenv/autoload"
+	_ "modernc.org/sqlite"
 )
 
 // Service represents a service that interacts with a database.
@@ -21,6 +20,15 @@ type Service interface {
 	// The keys and values in the map are service-specific.
 	Health() map[string]string
 
+	// Ready reports whether the database is reachable and its schema is
+	// in place.
+	Ready(ctx context.Context) error
+
+	// Schema migrations
+	MigrateUp(ctx context.Context) (int, error)
+	MigrateDown(ctx context.Context, steps int) (int, error)
+	MigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+
 	// Close terminates the database connection.
 	// It returns an error if the connection cannot be closed.
 	Close() error
@@ -28,36 +36,135 @@ type Service interface {
 	DbInit() error
 
 	// User methods
-	GetUser(email string) (User, error)
-	GetUserID(email string) (string, error)
-	createUser(email string) (string, error)
+	GetUser(ctx context.Context, email string) (User, error)
+	GetUserID(ctx context.Context, email string) (string, error)
+	GetUserEmail(ctx context.Context, userID string) (string, error)
+	createUser(ctx context.Context, email string) (string, error)
+	GetUserRole(ctx context.Context, userID string) (Role, error)
+	SetUserRole(ctx context.Context, userID string, role Role) error
+
+	// Login challenge methods
+	SaveLoginChallenge(ctx context.Context, email, codeHash string, expiresAt time.Time) error
+	ConsumeLoginChallenge(ctx context.Context, email, codeHash string, now time.Time) (bool, error)
+	LoginLockedUntil(ctx context.Context, email string, now time.Time) (time.Time, error)
+	RecordFailedLogin(ctx context.Context, email string, now time.Time, maxAttempts int, lockout time.Duration) (time.Time, error)
+	ResetFailedLogins(ctx context.Context, email string) error
+	DeleteExpiredLoginChallenges(ctx context.Context, before time.Time) (int64, error)
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error
+	SaveLoginLink(ctx context.Context, tokenHash, email string, expiresAt time.Time) error
+	ConsumeLoginLink(ctx context.Context, tokenHash string, now time.Time) (string, bool, error)
+
+	// Session methods
+	GetSession(ctx context.Context, id string, now time.Time) (*Session, error)
+	SaveSession(ctx context.Context, session *Session) error
+	TouchSession(ctx context.Context, id string, now, notSeenSince time.Time) error
+	DeleteSession(ctx context.Context, id string) error
+	ListUserSessions(ctx context.Context, userID string, now time.Time) ([]Session, error)
+	DeleteUserSession(ctx context.Context, userID, id string) error
+	DeleteUserSessions(ctx context.Context, userID string) (int64, error)
+	DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error)
+
 	CreateProduct(ctx context.Context, product *Product) error
 	GetProduct(ctx context.Context, id string) (*Product, error)
-	ListProducts(ctx context.Context) ([]*Product, error)
+	ListProducts(ctx context.Context, filter ProductFilter) (*ProductPage, error)
+	SearchProducts(ctx context.Context, query string, limit int) ([]ProductSearchResult, error)
 	UpdateProduct(ctx context.Context, product *Product) error
 	DeleteProduct(ctx context.Context, id string) error
+
+	// Tag methods
+	CreateTag(ctx context.Context, name string) (*Tag, error)
+	GetTagByName(ctx context.Context, name string) (*Tag, error)
+	ListTags(ctx context.Context) ([]Tag, error)
+	RenameTag(ctx context.Context, id, name string) (*Tag, error)
+	DeleteTag(ctx context.Context, id string) error
+	AttachTag(ctx context.Context, productID, name string) (*Tag, error)
+	DetachTag(ctx context.Context, productID, name string) error
+	ListProductTags(ctx context.Context, productIDs ...string) (map[string][]string, error)
+
+	// Review methods
+	CreateReview(ctx context.Context, review *Review) error
+	UpdateReview(ctx context.Context, review *Review) error
+	DeleteReview(ctx context.Context, userID, productID, reviewID string) error
+	ListReviews(ctx context.Context, productID string) ([]Review, error)
+	GetRatingSummaries(ctx context.Context, productIDs ...string) (map[string]RatingSummary, error)
+	HasDeliveredOrder(ctx context.Context, userID, productID string) (bool, error)
+
+	// Variant methods
+	CreateVariant(ctx context.Context, variant *Inventory) error
+	UpdateVariant(ctx context.Context, variant *Inventory) error
+	DeleteVariant(ctx context.Context, productID, variantID string) error
+	ListVariants(ctx context.Context, productIDs ...string) (map[string][]Inventory, error)
+
+	// Order methods
+	CreateOrder(ctx context.Context, userID string, input OrderInput) (*OrderDetails, error)
+	GetOrder(ctx context.Context, id string) (*OrderDetails, error)
+	ListOrders(ctx context.Context, userID string) ([]*OrderDetails, error)
+	ListAllOrders(ctx context.Context, status OrderStatus) ([]*OrderDetails, error)
+	UpdateOrderStatus(ctx context.Context, orderID string, status OrderStatus, changedBy, note string) (*OrderDetails, error)
+	GetOrderStatusHistory(ctx context.Context, orderID string) ([]OrderStatusHistory, error)
+	ExpirePendingOrders(ctx context.Context, before time.Time) (int, error)
+
+	// Discount methods
+	CreateDiscount(ctx context.Context, input DiscountInput) (*DiscountDetails, error)
+	UpdateDiscount(ctx context.Context, id string, input DiscountInput) (*DiscountDetails, error)
+	GetDiscount(ctx context.Context, id string) (*DiscountDetails, error)
+	ListDiscounts(ctx context.Context) ([]DiscountDetails, error)
+
+	// Payment methods
+	CreatePayment(ctx context.Context, payment *Payment) error
+	GetOrderPayment(ctx context.Context, orderID string) (*Payment, error)
+	SetPaymentStatus(ctx context.Context, id, status string) error
+	ApplyPaymentEvent(ctx context.Context, event PaymentEventInput) (*OrderDetails, error)
+
+	// Idempotency key methods
+	ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey, staleBefore time.Time) (*IdempotencyKey, bool, error)
+	SaveIdempotentResponse(ctx context.Context, userID, key string, statusCode int, contentType string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, userID, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+
+	// Subscription methods
+	CreateSubscription(ctx context.Context, userID string, input SubscriptionInput) (*SubscriptionDetails, error)
+	GetSubscription(ctx context.Context, id string) (*SubscriptionDetails, error)
+	ListSubscriptions(ctx context.Context, userID string) ([]SubscriptionDetails, error)
+	PauseSubscription(ctx context.Context, userID, id string) (*SubscriptionDetails, error)
+	ResumeSubscription(ctx context.Context, userID, id string, now time.Time) (*SubscriptionDetails, error)
+	SkipSubscription(ctx context.Context, userID, id string, now time.Time) (*SubscriptionDetails, error)
+	CancelSubscription(ctx context.Context, userID, id string) (*SubscriptionDetails, error)
+	ListSubscriptionsToRemind(ctx context.Context, from, until time.Time) ([]SubscriptionDetails, error)
+	MarkSubscriptionReminded(ctx context.Context, id string) error
+	ListDueSubscriptions(ctx context.Context, now time.Time) ([]SubscriptionDetails, error)
+	RunSubscription(ctx context.Context, id string, charges Charges, now time.Time) (*OrderDetails, error)
+
+	// Cart methods
+	GetCart(ctx context.Context, userID string) (*CartDetails, error)
+	AddCartItem(ctx context.Context, userID string, item CartItemInput) (*CartDetails, error)
+	UpdateCartItem(ctx context.Context, userID, itemID string, quantity int64) (*CartDetails, error)
+	RemoveCartItem(ctx context.Context, userID, itemID string) (*CartDetails, error)
+	ClearCart(ctx context.Context, userID string) error
+	MergeCarts(ctx context.Context, fromUserID, toUserID string) error
 }
 
 type service struct {
 	db *sql.DB
 	q  *Queries // Add a Queries instance
+
+	migrations []Migration
 }
 
 var (
-	dburl      = os.Getenv("BLUEPRINT_DB_URL")
+	dburl      string
 	dbInstance *service
 )
 
-//go:embed schema.sql
-var ddl string
-
-func NewDB() Service {
+// NewDB opens the SQLite database at url. The schema is set up by MigrateUp.
+func NewDB(url string) Service {
 	// Reuse Connection
 	if dbInstance != nil {
 		return dbInstance
 	}
+	dburl = url
 
-	db, err := sql.Open("sqlite3", dburl)
+	db, err := sql.Open("sqlite", dsn(dburl))
 	if err != nil {
 		// This will not be a connection error, but a DSN parse error or
 		// another initialization error.
@@ -65,7 +172,8 @@ func NewDB() Service {
 		return nil
 	}
 
-	if _, err := db.ExecContext(context.Background(), ddl); err != nil {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
 		log.Fatal(err)
 		return nil
 	}
@@ -75,10 +183,23 @@ func NewDB() Service {
 	dbInstance = &service{
 		db: db,
 		q:  q, // Assign the Queries instance
+
+		migrations: migrations,
 	}
 	return dbInstance
 }
 
+// dsn adds the connection settings the queries rely on to a database path:
+// writers wait for each other instead of failing with SQLITE_BUSY, and times
+// are stored in SQLite's own format so they compare and sort as text.
+func dsn(url string) string {
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	return url + sep + "_pragma=busy_timeout(5000)&_time_format=sqlite"
+}
+
 // Health checks the health of the database connection by pinging the database.
 // It returns a map with keys indicating various health statistics.
 func (s *service) Health() map[string]string {
@@ -92,7 +213,6 @@ func (s *service) Health() map[string]string {
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf("db down: %v", err) // Log the error and terminate the program
 		return stats
 	}
 
@@ -130,11 +250,29 @@ func (s *service) Health() map[string]string {
 	return stats
 }
 
+// Ready reports whether the database is reachable and every migration has
+// been applied, for the readiness probe.
+func (s *service) Ready(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("error pinging database: %w", err)
+	}
+	statuses, err := s.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range statuses {
+		if !m.Applied {
+			return fmt.Errorf("migration %d_%s is not applied", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
 // Close closes the database connection.
 // It logs a message indicating the disconnection from the specific database.
 // If the connection is successfully closed, it returns nil.
 // If an error occurs while closing the connection, it returns the error.
 func (s *service) Close() error {
-	log.Printf("Disconnected from database: %s", dburl)
+	slog.Info("Disconnected from database", "url", dburl)
 	return s.db.Close()
 }
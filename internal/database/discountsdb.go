@@ -0,0 +1,554 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DiscountKind is what a discount code takes off an order.
+type DiscountKind string
+
+const (
+	// DiscountPercentage takes Value percent off the eligible items.
+	DiscountPercentage DiscountKind = "percentage"
+	// DiscountFixed takes Value soles off the eligible items.
+	DiscountFixed DiscountKind = "fixed"
+	// DiscountFreeShipping waives the shipping fee.
+	DiscountFreeShipping DiscountKind = "free_shipping"
+)
+
+// Discount is a discount code.
+type Discount struct {
+	ID             string
+	Code           string
+	Description    sql.NullString
+	Kind           string
+	Value          float64
+	MinOrderAmount float64
+	StartsAt       sql.NullTime
+	EndsAt         sql.NullTime
+	MaxUses        sql.NullInt64
+	MaxUsesPerUser sql.NullInt64
+	Active         bool
+	CreatedAt      sql.NullTime
+	UpdatedAt      sql.NullTime
+}
+
+// ParseDiscountKind validates a discount kind name.
+func ParseDiscountKind(s string) (DiscountKind, error) {
+	switch k := DiscountKind(s); k {
+	case DiscountPercentage, DiscountFixed, DiscountFreeShipping:
+		return k, nil
+	}
+	return "", fmt.Errorf("unknown discount kind %q", s)
+}
+
+var (
+	// ErrDiscountNotFound is returned when a discount does not exist.
+	ErrDiscountNotFound = errors.New("discount not found")
+	// ErrDiscountExists is returned when a discount code is already taken.
+	ErrDiscountExists = errors.New("discount code already exists")
+	// ErrInvalidDiscount is returned when a discount definition is
+	// incomplete or inconsistent.
+	ErrInvalidDiscount = errors.New("invalid discount")
+	// ErrDiscountNotApplicable is returned when an order is placed with a
+	// code that is unknown, out of its validity window, used up, or that
+	// does not apply to the order.
+	ErrDiscountNotApplicable = errors.New("discount code cannot be applied")
+)
+
+// maxDiscountCodeLength bounds the length of a discount code.
+const maxDiscountCodeLength = 64
+
+// NormalizeDiscountCode trims and uppercases a discount code so customers
+// can type it in any case.
+func NormalizeDiscountCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// DiscountInput holds everything needed to define a discount code.
+type DiscountInput struct {
+	Code        string
+	Description string
+	Kind        DiscountKind
+	// Value is the percentage or the amount in soles taken off. Free
+	// shipping codes have none.
+	Value float64
+	// MinOrderAmount is the subtotal an order needs for the code to apply.
+	MinOrderAmount float64
+	// StartsAt and EndsAt bound when the code can be used, open when zero.
+	StartsAt time.Time
+	EndsAt   time.Time
+	// MaxUses and MaxUsesPerUser limit how many orders can be placed with
+	// the code, unlimited when zero.
+	MaxUses        int64
+	MaxUsesPerUser int64
+	// ProductIDs and Tags restrict the code to those items. A code without
+	// either applies to every item.
+	ProductIDs []string
+	Tags       []string
+	Active     bool
+}
+
+func (in *DiscountInput) validate() error {
+	in.Code = NormalizeDiscountCode(in.Code)
+	if in.Code == "" || len(in.Code) > maxDiscountCodeLength {
+		return fmt.Errorf("%w: a code of up to %d characters is required", ErrInvalidDiscount, maxDiscountCodeLength)
+	}
+	if _, err := ParseDiscountKind(string(in.Kind)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDiscount, err)
+	}
+	switch in.Kind {
+	case DiscountPercentage:
+		if in.Value <= 0 || in.Value > 100 {
+			return fmt.Errorf("%w: a percentage must be more than 0 and at most 100", ErrInvalidDiscount)
+		}
+	case DiscountFixed:
+		if in.Value <= 0 {
+			return fmt.Errorf("%w: a fixed discount must be a positive amount", ErrInvalidDiscount)
+		}
+	case DiscountFreeShipping:
+		in.Value = 0
+	}
+	if in.MinOrderAmount < 0 || in.MaxUses < 0 || in.MaxUsesPerUser < 0 {
+		return fmt.Errorf("%w: the minimum order and usage limits cannot be negative", ErrInvalidDiscount)
+	}
+	if !in.StartsAt.IsZero() && !in.EndsAt.IsZero() && !in.EndsAt.After(in.StartsAt) {
+		return fmt.Errorf("%w: a code must end after it starts", ErrInvalidDiscount)
+	}
+	return nil
+}
+
+// DiscountDetails is a discount together with its restrictions and the
+// number of orders placed with it.
+type DiscountDetails struct {
+	Discount
+	ProductIDs []string
+	Tags       []string
+	Uses       int64
+}
+
+const discountQuery = `
+	SELECT id, code, description, kind, value, min_order_amount, starts_at, ends_at, max_uses, max_uses_per_user,
+		active, created_at, updated_at
+	FROM discounts
+`
+
+func scanDiscount(row interface{ Scan(...interface{}) error }) (*Discount, error) {
+	d := &Discount{}
+	err := row.Scan(&d.ID, &d.Code, &d.Description, &d.Kind, &d.Value, &d.MinOrderAmount, &d.StartsAt, &d.EndsAt,
+		&d.MaxUses, &d.MaxUsesPerUser, &d.Active, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// CreateDiscount creates a discount code.
+func (s *service) CreateDiscount(ctx context.Context, input DiscountInput) (*DiscountDetails, error) {
+	if err := input.validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	id := uuid.New().String()
+	now := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO discounts (id, code, description, kind, value, min_order_amount, starts_at, ends_at, max_uses,
+			max_uses_per_user, active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, input.Code, nullString(input.Description), string(input.Kind), input.Value, input.MinOrderAmount,
+		nullTime(input.StartsAt), nullTime(input.EndsAt), nullInt(input.MaxUses), nullInt(input.MaxUsesPerUser),
+		input.Active, now, now)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDiscountExists
+		}
+		return nil, fmt.Errorf("error creating discount: %w", err)
+	}
+	if err := setDiscountRestrictions(ctx, tx, id, input); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing discount: %w", err)
+	}
+	return s.GetDiscount(ctx, id)
+}
+
+// UpdateDiscount replaces the definition of a discount code. Orders already
+// placed with it keep their discount.
+func (s *service) UpdateDiscount(ctx context.Context, id string, input DiscountInput) (*DiscountDetails, error) {
+	if err := input.validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE discounts
+		SET code = ?, description = ?, kind = ?, value = ?, min_order_amount = ?, starts_at = ?, ends_at = ?,
+			max_uses = ?, max_uses_per_user = ?, active = ?, updated_at = ?
+		WHERE id = ?
+	`, input.Code, nullString(input.Description), string(input.Kind), input.Value, input.MinOrderAmount,
+		nullTime(input.StartsAt), nullTime(input.EndsAt), nullInt(input.MaxUses), nullInt(input.MaxUsesPerUser),
+		input.Active, time.Now().UTC(), id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDiscountExists
+		}
+		return nil, fmt.Errorf("error updating discount: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("error updating discount: %w", err)
+	}
+	if n == 0 {
+		return nil, ErrDiscountNotFound
+	}
+	if err := setDiscountRestrictions(ctx, tx, id, input); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing discount: %w", err)
+	}
+	return s.GetDiscount(ctx, id)
+}
+
+// setDiscountRestrictions replaces the products and tags a discount is
+// restricted to. Unknown products and tags are rejected rather than
+// ignored, since dropping them would widen the code to every item.
+func setDiscountRestrictions(ctx context.Context, tx *sql.Tx, id string, input DiscountInput) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM discount_products WHERE discount_id = ?`, id); err != nil {
+		return fmt.Errorf("error clearing discount products: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM discount_tags WHERE discount_id = ?`, id); err != nil {
+		return fmt.Errorf("error clearing discount tags: %w", err)
+	}
+
+	for _, productID := range input.ProductIDs {
+		var exists int
+		err := tx.QueryRowContext(ctx, `SELECT count(*) FROM products WHERE id = ?`, productID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error getting product: %w", err)
+		}
+		if exists == 0 {
+			return fmt.Errorf("%w: unknown product %s", ErrInvalidDiscount, productID)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO discount_products (discount_id, product_id) VALUES (?, ?) ON CONFLICT DO NOTHING
+		`, id, productID)
+		if err != nil {
+			return fmt.Errorf("error restricting discount to product: %w", err)
+		}
+	}
+
+	for _, name := range input.Tags {
+		var tagID string
+		err := tx.QueryRowContext(ctx, `SELECT id FROM tags WHERE name = ?`, NormalizeTagName(name)).Scan(&tagID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%w: unknown tag %q", ErrInvalidDiscount, name)
+			}
+			return fmt.Errorf("error getting tag: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO discount_tags (discount_id, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING
+		`, id, tagID)
+		if err != nil {
+			return fmt.Errorf("error restricting discount to tag: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetDiscount retrieves a discount by ID.
+func (s *service) GetDiscount(ctx context.Context, id string) (*DiscountDetails, error) {
+	d, err := scanDiscount(s.db.QueryRowContext(ctx, discountQuery+`WHERE id = ?`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDiscountNotFound
+		}
+		return nil, fmt.Errorf("error getting discount: %w", err)
+	}
+	return discountDetails(ctx, s.db, d)
+}
+
+// ListDiscounts retrieves all discounts, newest first.
+func (s *service) ListDiscounts(ctx context.Context) ([]DiscountDetails, error) {
+	rows, err := s.db.QueryContext(ctx, discountQuery+`ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing discounts: %w", err)
+	}
+	defer rows.Close()
+
+	var discounts []*Discount
+	for rows.Next() {
+		d, err := scanDiscount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning discount: %w", err)
+		}
+		discounts = append(discounts, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating discounts: %w", err)
+	}
+
+	details := make([]DiscountDetails, 0, len(discounts))
+	for _, d := range discounts {
+		dd, err := discountDetails(ctx, s.db, d)
+		if err != nil {
+			return nil, err
+		}
+		details = append(details, *dd)
+	}
+	return details, nil
+}
+
+// discountDetails loads the restrictions and uses of a discount.
+func discountDetails(ctx context.Context, db DBTX, d *Discount) (*DiscountDetails, error) {
+	details := &DiscountDetails{Discount: *d}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT product_id FROM discount_products WHERE discount_id = ? ORDER BY product_id
+	`, d.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing discount products: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var productID string
+		if err := rows.Scan(&productID); err != nil {
+			return nil, fmt.Errorf("error scanning discount product: %w", err)
+		}
+		details.ProductIDs = append(details.ProductIDs, productID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating discount products: %w", err)
+	}
+
+	tagRows, err := db.QueryContext(ctx, `
+		SELECT t.name
+		FROM discount_tags dt
+		JOIN tags t ON t.id = dt.tag_id
+		WHERE dt.discount_id = ?
+		ORDER BY t.name
+	`, d.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing discount tags: %w", err)
+	}
+	defer tagRows.Close()
+	for tagRows.Next() {
+		var name string
+		if err := tagRows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error scanning discount tag: %w", err)
+		}
+		details.Tags = append(details.Tags, name)
+	}
+	if err := tagRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating discount tags: %w", err)
+	}
+
+	details.Uses, err = discountUses(ctx, db, d.ID, "")
+	if err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// discountUses counts the orders placed with a discount, by one user when
+// userID is set. Orders that were cancelled or refunded give their use back.
+func discountUses(ctx context.Context, db DBTX, discountID, userID string) (int64, error) {
+	var n int64
+	err := db.QueryRowContext(ctx, `
+		SELECT count(*)
+		FROM discount_redemptions r
+		JOIN orders o ON o.id = r.order_id
+		WHERE r.discount_id = ? AND (? = '' OR r.user_id = ?) AND o.order_status NOT IN (?, ?)
+	`, discountID, userID, userID, string(OrderCancelled), string(OrderRefunded)).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("error counting discount uses: %w", err)
+	}
+	return n, nil
+}
+
+// applyDiscount checks that the discount code can be used on an order being
+// placed at now, and sets the discount of the order and of its items.
+func applyDiscount(ctx context.Context, tx *sql.Tx, order *OrderDetails, code string, now time.Time) (*Discount, error) {
+	code = NormalizeDiscountCode(code)
+	d, err := scanDiscount(tx.QueryRowContext(ctx, discountQuery+`WHERE code = ?`, code))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("error getting discount: %w", err)
+	}
+	if err == sql.ErrNoRows || !d.Active {
+		return nil, fmt.Errorf("%w: unknown code %s", ErrDiscountNotApplicable, code)
+	}
+
+	switch {
+	case d.StartsAt.Valid && now.Before(d.StartsAt.Time):
+		return nil, fmt.Errorf("%w: %s is not valid yet", ErrDiscountNotApplicable, code)
+	case d.EndsAt.Valid && !now.Before(d.EndsAt.Time):
+		return nil, fmt.Errorf("%w: %s has expired", ErrDiscountNotApplicable, code)
+	case order.SubtotalAmount < d.MinOrderAmount:
+		return nil, fmt.Errorf("%w: %s needs an order of at least S/ %.2f", ErrDiscountNotApplicable, code, d.MinOrderAmount)
+	}
+
+	if d.MaxUses.Valid {
+		uses, err := discountUses(ctx, tx, d.ID, "")
+		if err != nil {
+			return nil, err
+		}
+		if uses >= d.MaxUses.Int64 {
+			return nil, fmt.Errorf("%w: %s has been used up", ErrDiscountNotApplicable, code)
+		}
+	}
+	if d.MaxUsesPerUser.Valid {
+		uses, err := discountUses(ctx, tx, d.ID, order.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if uses >= d.MaxUsesPerUser.Int64 {
+			return nil, fmt.Errorf("%w: you have already used %s", ErrDiscountNotApplicable, code)
+		}
+	}
+
+	eligible, err := eligibleItems(ctx, tx, d.ID, order.Items)
+	if err != nil {
+		return nil, err
+	}
+	applies := false
+	for _, ok := range eligible {
+		applies = applies || ok
+	}
+	if !applies {
+		return nil, fmt.Errorf("%w: %s does not apply to these items", ErrDiscountNotApplicable, code)
+	}
+
+	lines, shipping := discountAmounts(d, order.Items, eligible, order.ShippingAmount)
+	var total float64
+	for i := range order.Items {
+		order.Items[i].DiscountAmount = lines[i]
+		total += lines[i]
+	}
+	order.DiscountAmount = roundCents(total + shipping)
+	order.DiscountCode = sql.NullString{String: d.Code, Valid: true}
+	return d, nil
+}
+
+// eligibleItems tells which order items a discount applies to: all of them
+// when it is not restricted, otherwise those whose product is listed or has
+// one of the listed tags.
+func eligibleItems(ctx context.Context, tx *sql.Tx, discountID string, items []OrderItem) ([]bool, error) {
+	var restrictions int
+	err := tx.QueryRowContext(ctx, `
+		SELECT (SELECT count(*) FROM discount_products WHERE discount_id = ?)
+			+ (SELECT count(*) FROM discount_tags WHERE discount_id = ?)
+	`, discountID, discountID).Scan(&restrictions)
+	if err != nil {
+		return nil, fmt.Errorf("error getting discount restrictions: %w", err)
+	}
+
+	eligible := make([]bool, len(items))
+	for i, item := range items {
+		if restrictions == 0 {
+			eligible[i] = true
+			continue
+		}
+		var matches int
+		err := tx.QueryRowContext(ctx, `
+			SELECT (SELECT count(*) FROM discount_products WHERE discount_id = ? AND product_id = ?)
+				+ (SELECT count(*)
+					FROM discount_tags dt
+					JOIN product_tags pt ON pt.tag_id = dt.tag_id
+					WHERE dt.discount_id = ? AND pt.product_id = ?)
+		`, discountID, item.ProductID, discountID, item.ProductID).Scan(&matches)
+		if err != nil {
+			return nil, fmt.Errorf("error matching discount restrictions: %w", err)
+		}
+		eligible[i] = matches > 0
+	}
+	return eligible, nil
+}
+
+// discountAmounts splits what a discount takes off an order between its
+// items and its shipping. A fixed amount is spread over the eligible items
+// in proportion to their totals, and never exceeds them.
+func discountAmounts(d *Discount, items []OrderItem, eligible []bool, shipping float64) ([]float64, float64) {
+	lines := make([]float64, len(items))
+	switch DiscountKind(d.Kind) {
+	case DiscountPercentage:
+		for i, item := range items {
+			if eligible[i] {
+				lines[i] = roundCents(item.Price * float64(item.Quantity) * d.Value / 100)
+			}
+		}
+	case DiscountFixed:
+		var base float64
+		last := -1
+		for i, item := range items {
+			if eligible[i] {
+				base += item.Price * float64(item.Quantity)
+				last = i
+			}
+		}
+		amount := roundCents(min(d.Value, base))
+		if amount <= 0 {
+			return lines, 0
+		}
+		// The last eligible item takes the rounding remainder so the lines
+		// add up to the amount exactly
+		left := amount
+		for i, item := range items {
+			if !eligible[i] {
+				continue
+			}
+			if i == last {
+				lines[i] = roundCents(left)
+				break
+			}
+			lines[i] = roundCents(amount * item.Price * float64(item.Quantity) / base)
+			left -= lines[i]
+		}
+	case DiscountFreeShipping:
+		return lines, shipping
+	}
+	return lines, 0
+}
+
+// redeemDiscount records that an order was placed with a discount.
+func redeemDiscount(ctx context.Context, tx *sql.Tx, discountID string, order *OrderDetails, now time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO discount_redemptions (id, discount_id, order_id, user_id, amount, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, uuid.New().String(), discountID, order.ID, order.UserID, order.DiscountAmount, now)
+	if err != nil {
+		return fmt.Errorf("error redeeming discount: %w", err)
+	}
+	return nil
+}
+
+// nullTime converts a zero time to a NULL value.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// nullInt converts zero to a NULL value.
+func nullInt(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: n != 0}
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDiscountAmounts(t *testing.T) {
+	items := []OrderItem{
+		{ProductID: "a", Price: 10, Quantity: 2},
+		{ProductID: "b", Price: 15, Quantity: 1},
+		{ProductID: "c", Price: 5, Quantity: 1},
+	}
+	tests := []struct {
+		name     string
+		discount Discount
+		eligible []bool
+		lines    []float64
+		shipping float64
+	}{
+		{"percentage", Discount{Kind: string(DiscountPercentage), Value: 10}, []bool{true, true, false}, []float64{2, 1.5, 0}, 0},
+		{"fixed spread", Discount{Kind: string(DiscountFixed), Value: 10}, []bool{true, true, true}, []float64{5, 3.75, 1.25}, 0},
+		{"fixed rounding", Discount{Kind: string(DiscountFixed), Value: 1}, []bool{true, true, true}, []float64{0.5, 0.38, 0.12}, 0},
+		{"fixed capped", Discount{Kind: string(DiscountFixed), Value: 50}, []bool{false, true, false}, []float64{0, 15, 0}, 0},
+		{"free shipping", Discount{Kind: string(DiscountFreeShipping)}, []bool{true, true, true}, []float64{0, 0, 0}, 8},
+	}
+	for _, tt := range tests {
+		lines, shipping := discountAmounts(&tt.discount, items, tt.eligible, 8)
+		for i := range lines {
+			if lines[i] != tt.lines[i] {
+				t.Errorf("%s: line %d = %v; want %v", tt.name, i, lines[i], tt.lines[i])
+			}
+		}
+		if shipping != tt.shipping {
+			t.Errorf("%s: shipping = %v; want %v", tt.name, shipping, tt.shipping)
+		}
+	}
+}
+
+func TestDiscountInputValidate(t *testing.T) {
+	start := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	valid := []DiscountInput{
+		{Code: " summer10 ", Kind: DiscountPercentage, Value: 10},
+		{Code: "LESS5", Kind: DiscountFixed, Value: 5, MinOrderAmount: 30, MaxUses: 100, MaxUsesPerUser: 1},
+		{Code: "SHIPFREE", Kind: DiscountFreeShipping, StartsAt: start, EndsAt: start.AddDate(0, 0, 7)},
+	}
+	for _, in := range valid {
+		if err := in.validate(); err != nil {
+			t.Errorf("expected %s to be valid; got %v", in.Code, err)
+		}
+	}
+
+	invalid := []DiscountInput{
+		{Code: "", Kind: DiscountPercentage, Value: 10},
+		{Code: "BOGO", Kind: DiscountKind("bogo"), Value: 1},
+		{Code: "TOOMUCH", Kind: DiscountPercentage, Value: 120},
+		{Code: "NOTHING", Kind: DiscountFixed},
+		{Code: "NEGATIVE", Kind: DiscountFixed, Value: 5, MaxUses: -1},
+		{Code: "BACKWARDS", Kind: DiscountFreeShipping, StartsAt: start, EndsAt: start},
+	}
+	for _, in := range invalid {
+		if err := in.validate(); !errors.Is(err, ErrInvalidDiscount) {
+			t.Errorf("expected %q to be invalid; got %v", in.Code, err)
+		}
+	}
+
+	in := DiscountInput{Code: " summer10 ", Kind: DiscountPercentage, Value: 10}
+	if err := in.validate(); err != nil || in.Code != "SUMMER10" {
+		t.Errorf("expected the code to be normalized to SUMMER10; got %q, %v", in.Code, err)
+	}
+}
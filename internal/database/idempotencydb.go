@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// IdempotencyKey is an Idempotency-Key sent by a user and the response
+// stored for it.
+type IdempotencyKey struct {
+	UserID       string
+	Key          string
+	RequestHash  string
+	StatusCode   sql.NullInt64
+	ContentType  string
+	ResponseBody []byte
+	CreatedAt    time.Time
+}
+
+// ReserveIdempotencyKey claims a key for a request about to run. It returns
+// true when the key is new, or was left in progress by a request that
+// started before staleBefore and never finished. Otherwise it returns the
+// stored key, with its response once the first request has finished.
+func (s *service) ReserveIdempotencyKey(ctx context.Context, key *IdempotencyKey, staleBefore time.Time) (*IdempotencyKey, bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (user_id, key, request_hash, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET request_hash = excluded.request_hash, created_at = excluded.created_at
+		WHERE idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < ?
+	`, key.UserID, key.Key, key.RequestHash, key.CreatedAt.UTC(), staleBefore.UTC())
+	if err != nil {
+		return nil, false, fmt.Errorf("error reserving idempotency key: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, false, fmt.Errorf("error reserving idempotency key: %w", err)
+	}
+	if n > 0 {
+		return nil, true, nil
+	}
+
+	stored := &IdempotencyKey{}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT user_id, key, request_hash, status_code, content_type, response_body, created_at
+		FROM idempotency_keys
+		WHERE user_id = ? AND key = ?
+	`, key.UserID, key.Key).Scan(&stored.UserID, &stored.Key, &stored.RequestHash, &stored.StatusCode,
+		&stored.ContentType, &stored.ResponseBody, &stored.CreatedAt)
+	if err != nil {
+		// Released between the insert and the select
+		if err == sql.ErrNoRows {
+			return s.ReserveIdempotencyKey(ctx, key, staleBefore)
+		}
+		return nil, false, fmt.Errorf("error getting idempotency key: %w", err)
+	}
+	return stored, false, nil
+}
+
+// SaveIdempotentResponse stores the response of the request that reserved a
+// key, for retries to get back.
+func (s *service) SaveIdempotentResponse(ctx context.Context, userID, key string, statusCode int, contentType string, body []byte) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status_code = ?, content_type = ?, response_body = ?
+		WHERE user_id = ? AND key = ?
+	`, statusCode, contentType, body, userID, key)
+	if err != nil {
+		return fmt.Errorf("error saving idempotent response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets a key so the request can be retried with it.
+func (s *service) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?`, userID, key)
+	if err != nil {
+		return fmt.Errorf("error releasing idempotency key: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys removes the keys created before the given
+// time. It returns how many were removed.
+func (s *service) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created_at < ?`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired idempotency keys: %w", err)
+	}
+	return n, nil
+}
@@ -2,10 +2,9 @@ package database
 
 import (
 	"context"
-	"fmt"
-	"log"
 
 	"database/sql"
+	"log/slog"
 
 	"github.com/google/uuid"
 )
@@ -17,7 +16,7 @@ func (s *service) DbInit() error {
 	// Populate the products table with example products
 	err := s.populateproductsTable()
 	if err != nil {
-		log.Println("Error populating products table:", err)
+		slog.Error("Error populating products table", "error", err)
 		return err
 	}
 
@@ -35,68 +34,53 @@ func (s *service) populateproductsTable() error {
 		)
 	`).Scan(&tablePopulated)
 	if err != nil {
-		log.Println("Error checking if products table is populated:", err)
+		slog.Error("Error checking if products table is populated", "error", err)
 		return err
 	}
 
 	// If the products table is not populated, insert the product data
 	if !tablePopulated {
-		log.Println("products table is not populated, inserting product data...")
+		slog.Info("products table is not populated, inserting product data")
 
 		// Define products
 		products := []Product{
 			{
-				Code: "BEAN001",
-				Images: sql.NullString{
-					String: "whole_bean1.jpg, whole_bean2.jpg",
-					Valid:  true,
-				},
-				Title: "Peruvian Whole Bean Coffee",
+				Code:   "BEAN001",
+				Images: EncodeImages([]string{"whole_bean1.jpg", "whole_bean2.jpg"}),
+				Title:  "Peruvian Whole Bean Coffee",
 				Description: sql.NullString{
 					String: "High-altitude Arabica beans, perfect for home roasting.",
 					Valid:  true,
 				},
 			},
 			{
-				Code: "DRINK001",
-				Images: sql.NullString{
-					String: "cappuccino1.jpg, cappuccino2.jpg",
-					Valid:  true,
-				},
-				Title: "Classic Cappuccino",
+				Code:   "DRINK001",
+				Images: EncodeImages([]string{"cappuccino1.jpg", "cappuccino2.jpg"}),
+				Title:  "Classic Cappuccino",
 				Description: sql.NullString{
 					String: "Espresso with steamed milk and foamed milk.",
 					Valid:  true},
 			},
 			{
-				Code: "BLEND002",
-				Images: sql.NullString{
-					String: "signature_blend1.jpg, signature_blend2.jpg",
-					Valid:  true,
-				},
-				Title: "Kaffino Signature Blend",
+				Code:   "BLEND002",
+				Images: EncodeImages([]string{"signature_blend1.jpg", "signature_blend2.jpg"}),
+				Title:  "Kaffino Signature Blend",
 				Description: sql.NullString{
 					String: "A unique blend of Peruvian and Ethiopian beans.",
 					Valid:  true},
 			},
 			{
-				Code: "ACC001",
-				Images: sql.NullString{
-					String: "french_press1.jpg, french_press2.jpg",
-					Valid:  true,
-				},
-				Title: "French Press",
+				Code:   "ACC001",
+				Images: EncodeImages([]string{"french_press1.jpg", "french_press2.jpg"}),
+				Title:  "French Press",
 				Description: sql.NullString{
 					String: "Classic coffee brewing device.",
 					Valid:  true},
 			},
 			{
-				Code: "GRIND001",
-				Images: sql.NullString{
-					String: "coffee_grinder1.jpg, coffee_grinder2.jpg",
-					Valid:  true,
-				},
-				Title: "Coffee Grinder",
+				Code:   "GRIND001",
+				Images: EncodeImages([]string{"coffee_grinder1.jpg", "coffee_grinder2.jpg"}),
+				Title:  "Coffee Grinder",
 				Description: sql.NullString{
 					String: "Electric coffee grinder for home use.",
 					Valid:  true,
@@ -109,33 +93,41 @@ func (s *service) populateproductsTable() error {
 			product.ID = uuid.New().String()
 			err := s.CreateProduct(context.Background(), &product)
 			if err != nil {
-				log.Println("Error inserting product data:", err)
+				slog.Error("Error inserting product data", "error", err)
 				return err
 			}
 
-			// Create inventory for the product
-			inventory := Inventory{
-				ID:        uuid.New().String(),
-				ProductID: product.ID,
-				Stock:     100,   // Example stock
-				Price:     15.00, // Example price
+			// Create the purchasable variants of the product
+			for _, variant := range seedVariants(product.Code) {
+				variant.ProductID = product.ID
+				err = s.CreateVariant(context.Background(), &variant)
+				if err != nil {
+					slog.Error("Error inserting inventory data", "error", err)
+					return err
+				}
 			}
-
-			_, err = s.db.Exec(`
-				INSERT INTO inventory (id, product_id, stock, sizes, price)
-				VALUES (?, ?, ?, ?, ?)
-			`, inventory.ID, inventory.ProductID, inventory.Stock, inventory.Sizes, inventory.Price)
-
-			if err != nil {
-				log.Println("Error inserting inventory data:", err)
-				return err
-			}
-			fmt.Printf("Inserted inventory for product %s\n", product.Title)
+			slog.Debug("Inserted inventory", "product", product.Title)
 		}
 
-		log.Println("products table populated successfully.")
+		slog.Info("products table populated successfully")
 	} else {
-		log.Println("products table is already populated.")
+		slog.Debug("products table is already populated")
 	}
 	return nil
 }
+
+// seedVariants returns the example variants for a seeded product.
+// Coffee comes in two bag sizes, everything else is sold as a single item.
+func seedVariants(code string) []Inventory {
+	switch code {
+	case "BEAN001", "BLEND002":
+		return []Inventory{
+			{Sizes: sql.NullString{String: "Full Bag (12oz)", Valid: true}, Stock: 100, Price: 15.00},
+			{Sizes: sql.NullString{String: "Half Bag (6oz)", Valid: true}, Stock: 100, Price: 8.50},
+		}
+	default:
+		return []Inventory{
+			{Sizes: sql.NullString{String: "Standard", Valid: true}, Stock: 100, Price: 15.00},
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInsufficientStock is returned when an order asks for more units than are in stock.
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrVariantNotFound is returned when a product variant does not exist.
+	ErrVariantNotFound = errors.New("variant not found")
+	// ErrVariantExists is returned when a product already has a variant of
+	// the same size.
+	ErrVariantExists = errors.New("variant size already exists")
+)
+
+// Reasons recorded in the inventory_movements ledger.
+const (
+	MovementRestock = "restock"
+	MovementReserve = "reserve"
+	MovementRelease = "release"
+	MovementAdjust  = "adjust"
+)
+
+// Inventory is one purchasable variant of a product, such as "Full Bag
+// (12oz)" or "Half Bag (6oz)", with its own price and stock. Sizes holds
+// the variant label shown to customers.
+type Inventory struct {
+	ID        string
+	ProductID string
+	Stock     int64
+	Sizes     sql.NullString
+	Price     float64
+	CreatedAt sql.NullTime
+	UpdatedAt sql.NullTime
+}
+
+// CreateVariant adds a variant to a product and records its opening stock.
+func (s *service) CreateVariant(ctx context.Context, variant *Inventory) error {
+	if variant.ID == "" {
+		variant.ID = uuid.New().String()
+	}
+	now := time.Now()
+	variant.CreatedAt = sql.NullTime{Time: now, Valid: true}
+	variant.UpdatedAt = sql.NullTime{Time: now, Valid: true}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO inventory (id, product_id, stock, sizes, price, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, variant.ID, variant.ProductID, variant.Stock, variant.Sizes, variant.Price, variant.CreatedAt, variant.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrVariantExists
+		}
+		return fmt.Errorf("error creating variant: %w", err)
+	}
+
+	if variant.Stock != 0 {
+		if err := recordMovement(ctx, tx, variant.ID, "", variant.Stock, MovementRestock); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing variant: %w", err)
+	}
+	return nil
+}
+
+// UpdateVariant changes the label, price and stock of a variant. A change in
+// stock is recorded in the ledger as an adjustment.
+func (s *service) UpdateVariant(ctx context.Context, variant *Inventory) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var stock int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT stock FROM inventory WHERE id = ? AND product_id = ?
+	`, variant.ID, variant.ProductID).Scan(&stock)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrVariantNotFound
+		}
+		return fmt.Errorf("error getting variant: %w", err)
+	}
+
+	variant.UpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE inventory
+		SET sizes = ?, price = ?, stock = ?, updated_at = ?
+		WHERE id = ?
+	`, variant.Sizes, variant.Price, variant.Stock, variant.UpdatedAt, variant.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrVariantExists
+		}
+		return fmt.Errorf("error updating variant: %w", err)
+	}
+
+	if delta := variant.Stock - stock; delta != 0 {
+		if err := recordMovement(ctx, tx, variant.ID, "", delta, MovementAdjust); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing variant: %w", err)
+	}
+	return nil
+}
+
+// DeleteVariant removes a variant from a product.
+func (s *service) DeleteVariant(ctx context.Context, productID, variantID string) error {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM inventory
+		WHERE id = ? AND product_id = ?
+	`, variantID, productID)
+	if err != nil {
+		return fmt.Errorf("error deleting variant: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrVariantNotFound
+	}
+	return nil
+}
+
+// ListVariants retrieves the variants of the given products, keyed by product ID.
+func (s *service) ListVariants(ctx context.Context, productIDs ...string) (map[string][]Inventory, error) {
+	variants := make(map[string][]Inventory)
+	if len(productIDs) == 0 {
+		return variants, nil
+	}
+
+	args := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+	query := `
+		SELECT id, product_id, stock, sizes, price, created_at, updated_at
+		FROM inventory
+		WHERE product_id IN (` + placeholders(len(productIDs)) + `)
+		ORDER BY price, created_at
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing variants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v Inventory
+		err := rows.Scan(&v.ID, &v.ProductID, &v.Stock, &v.Sizes, &v.Price, &v.CreatedAt, &v.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning variant: %w", err)
+		}
+		variants[v.ProductID] = append(variants[v.ProductID], v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating variants: %w", err)
+	}
+
+	return variants, nil
+}
+
+// findVariant returns the variant of a product sold in size. The size may
+// only be left out when the product comes in a single variant. Requests
+// that match no variant, or more than one, are refused with invalid.
+func findVariant(ctx context.Context, db DBTX, productID, size string, invalid error) (*Inventory, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, product_id, stock, sizes, price, created_at, updated_at
+		FROM inventory
+		WHERE product_id = ?
+		ORDER BY created_at
+	`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing variants: %w", err)
+	}
+	defer rows.Close()
+
+	var variants []Inventory
+	for rows.Next() {
+		var v Inventory
+		err := rows.Scan(&v.ID, &v.ProductID, &v.Stock, &v.Sizes, &v.Price, &v.CreatedAt, &v.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning variant: %w", err)
+		}
+		variants = append(variants, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating variants: %w", err)
+	}
+
+	switch {
+	case len(variants) == 0:
+		return nil, fmt.Errorf("%w: product %s is not available", invalid, productID)
+	case size == "" && len(variants) == 1:
+		return &variants[0], nil
+	case size == "":
+		return nil, fmt.Errorf("%w: product %s comes in several sizes, choose one", invalid, productID)
+	}
+	for i := range variants {
+		if variants[i].Sizes.String == size {
+			return &variants[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: product %s is not sold in size %q", invalid, productID, size)
+}
+
+// reserveStock takes the units of an order item out of stock and records
+// the movement. It returns the variant the units were taken from.
+func reserveStock(ctx context.Context, tx *sql.Tx, orderID string, item OrderItemInput) (*Inventory, error) {
+	var variant *Inventory
+	if item.VariantID != "" {
+		variant = &Inventory{}
+		err := tx.QueryRowContext(ctx, `
+			SELECT id, product_id, stock, sizes, price, created_at, updated_at
+			FROM inventory
+			WHERE id = ? AND product_id = ?
+		`, item.VariantID, item.ProductID).Scan(&variant.ID, &variant.ProductID, &variant.Stock, &variant.Sizes,
+			&variant.Price, &variant.CreatedAt, &variant.UpdatedAt)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, ErrVariantNotFound
+			}
+			return nil, fmt.Errorf("error getting variant: %w", err)
+		}
+	} else {
+		var err error
+		if variant, err = findVariant(ctx, tx, item.ProductID, item.Size, ErrInvalidOrder); err != nil {
+			return nil, err
+		}
+	}
+	productID, quantity := item.ProductID, item.Quantity
+
+	// The stock check and the decrement happen in one statement so two
+	// concurrent orders can never both take the last units.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE inventory
+		SET stock = stock - ?, updated_at = ?
+		WHERE id = ? AND stock >= ?
+	`, quantity, time.Now(), variant.ID, quantity)
+	if err != nil {
+		return nil, fmt.Errorf("error reserving stock: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("error reserving stock: %w", err)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%w for product %s", ErrInsufficientStock, productID)
+	}
+
+	if err := recordMovement(ctx, tx, variant.ID, orderID, -quantity, MovementReserve); err != nil {
+		return nil, err
+	}
+
+	return variant, nil
+}
+
+// releaseStock returns the stock still held by an order to the inventory.
+// It only gives back what has not already been released, so calling it
+// twice for the same order is harmless.
+func releaseStock(ctx context.Context, tx *sql.Tx, orderID string) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT inventory_id, SUM(quantity)
+		FROM inventory_movements
+		WHERE order_id = ?
+		GROUP BY inventory_id
+		HAVING SUM(quantity) < 0
+	`, orderID)
+	if err != nil {
+		return fmt.Errorf("error listing reservations: %w", err)
+	}
+
+	held := make(map[string]int64)
+	for rows.Next() {
+		var (
+			inventoryID string
+			quantity    int64
+		)
+		if err := rows.Scan(&inventoryID, &quantity); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning reservation: %w", err)
+		}
+		held[inventoryID] = -quantity
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating reservations: %w", err)
+	}
+
+	for inventoryID, quantity := range held {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE inventory
+			SET stock = stock + ?, updated_at = ?
+			WHERE id = ?
+		`, quantity, time.Now(), inventoryID)
+		if err != nil {
+			return fmt.Errorf("error releasing stock: %w", err)
+		}
+		if err := recordMovement(ctx, tx, inventoryID, orderID, quantity, MovementRelease); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordMovement appends an entry to the inventory ledger.
+func recordMovement(ctx context.Context, db DBTX, inventoryID, orderID string, quantity int64, reason string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO inventory_movements (id, inventory_id, order_id, quantity, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, uuid.New().String(), inventoryID, nullString(orderID), quantity, reason, time.Now())
+	if err != nil {
+		return fmt.Errorf("error recording inventory movement: %w", err)
+	}
+	return nil
+}
+
+// ExpirePendingOrders cancels orders that are still unpaid after the given
+// time and releases the stock they reserved. Orders with a payment waiting
+// to be captured are kept, so the customer is not cancelled while paying.
+// It returns how many orders were cancelled.
+func (s *service) ExpirePendingOrders(ctx context.Context, before time.Time) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id
+		FROM orders o
+		WHERE order_status = ? AND order_date < ?
+			AND NOT EXISTS (SELECT 1 FROM payments p WHERE p.order_id = o.id AND p.status = ?)
+	`, string(OrderPending), before, PaymentRequiresCapture)
+	if err != nil {
+		return 0, fmt.Errorf("error listing pending orders: %w", err)
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning pending order: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating pending orders: %w", err)
+	}
+
+	expired := 0
+	for _, id := range ids {
+		_, err := s.UpdateOrderStatus(ctx, id, OrderCancelled, "system", "reservation expired")
+		if err != nil {
+			// The order may have been paid in the meantime
+			if errors.Is(err, ErrInvalidTransition) {
+				continue
+			}
+			return expired, err
+		}
+		expired++
+	}
+
+	return expired, nil
+}
+
+// placeholders returns n comma separated query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
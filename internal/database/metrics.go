@@ -0,0 +1,47 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// poolStats returns the connection pool stats of the open database.
+func poolStats() sql.DBStats {
+	if dbInstance == nil {
+		return sql.DBStats{}
+	}
+	return dbInstance.db.Stats()
+}
+
+func init() {
+	gauge := func(name, help string, fn func(sql.DBStats) float64) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, func() float64 { return fn(poolStats()) })
+	}
+	counter := func(name, help string, fn func(sql.DBStats) float64) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 { return fn(poolStats()) })
+	}
+
+	gauge("kaffino_db_open_connections", "Open database connections.", func(s sql.DBStats) float64 {
+		return float64(s.OpenConnections)
+	})
+	gauge("kaffino_db_in_use_connections", "Database connections in use.", func(s sql.DBStats) float64 {
+		return float64(s.InUse)
+	})
+	gauge("kaffino_db_idle_connections", "Idle database connections.", func(s sql.DBStats) float64 {
+		return float64(s.Idle)
+	})
+	counter("kaffino_db_wait_count_total", "Times a query waited for a free connection.", func(s sql.DBStats) float64 {
+		return float64(s.WaitCount)
+	})
+	counter("kaffino_db_wait_duration_seconds_total", "Time spent waiting for a free connection.", func(s sql.DBStats) float64 {
+		return s.WaitDuration.Seconds()
+	})
+	counter("kaffino_db_max_idle_closed_total", "Connections closed for exceeding the idle limit.", func(s sql.DBStats) float64 {
+		return float64(s.MaxIdleClosed)
+	})
+	counter("kaffino_db_max_lifetime_closed_total", "Connections closed for exceeding their lifetime.", func(s sql.DBStats) float64 {
+		return float64(s.MaxLifetimeClosed)
+	})
+}
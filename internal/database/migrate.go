@@ -0,0 +1,318 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kaffino/internal/logging"
+)
+
+// Migrations live in migrations/ as NNNN_name.up.sql and NNNN_name.down.sql
+// pairs, applied in version order. Applied migrations must never be edited:
+// their checksum is recorded and checked on every run. Change the schema by
+// adding a new migration instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var (
+	// ErrChecksumMismatch is returned when an applied migration was edited
+	// after it ran.
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+	// ErrNoDownMigration is returned when rolling back a migration that
+	// has no down file.
+	ErrNoDownMigration = errors.New("migration has no down file")
+)
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus tells whether a migration has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// loadMigrations reads the migrations of a directory, sorted by version.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := migrationFileName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, m[2])
+		}
+		if m[3] == "up" {
+			migration.Up = string(body)
+			sum := sha256.Sum256(body)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func (s *service) hasTable(ctx context.Context, name string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("error checking table %s: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+// appliedMigrations returns the applied migrations by version. A database
+// without the schema_migrations table has none.
+func (s *service) appliedMigrations(ctx context.Context) (map[int]appliedMigration, error) {
+	applied := make(map[int]appliedMigration)
+	if ok, err := s.hasTable(ctx, "schema_migrations"); err != nil || !ok {
+		return applied, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var m appliedMigration
+		if err := rows.Scan(&version, &m.checksum, &m.appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning applied migration: %w", err)
+		}
+		applied[version] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// verifyChecksums checks that no applied migration was edited.
+func verifyChecksums(migrations []Migration, applied map[int]appliedMigration) error {
+	for _, m := range migrations {
+		if a, ok := applied[m.Version]; ok && a.checksum != m.Checksum {
+			return fmt.Errorf("%w: %d_%s was changed after it was applied", ErrChecksumMismatch, m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// MigrateUp applies the pending migrations in order, each in its own
+// transaction. It returns how many were applied.
+func (s *service) MigrateUp(ctx context.Context) (int, error) {
+	tracked, err := s.hasTable(ctx, "schema_migrations")
+	if err != nil {
+		return 0, err
+	}
+	// A database created before migrations existed already has the tables
+	// of the first migration, which only creates what is missing.
+	adopting := false
+	if !tracked {
+		if adopting, err = s.hasTable(ctx, "users"); err != nil {
+			return 0, err
+		}
+		if adopting {
+			logging.FromContext(ctx).Info("Adopting existing database into schema migrations")
+		}
+		_, err := s.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				version INTEGER PRIMARY KEY,
+				name TEXT NOT NULL,
+				checksum TEXT NOT NULL,
+				applied_at TIMESTAMP NOT NULL
+			)
+		`)
+		if err != nil {
+			return 0, fmt.Errorf("error creating migrations table: %w", err)
+		}
+	}
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := verifyChecksums(s.migrations, applied); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, m := range s.migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := s.applyMigration(ctx, m, adopting && m.Version == 1); err != nil {
+			return n, err
+		}
+		logging.FromContext(ctx).Info("Applied migration", "version", m.Version, "name", m.Name)
+		n++
+	}
+	return n, nil
+}
+
+func (s *service) applyMigration(ctx context.Context, m Migration, adopting bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting migration %d: %w", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	if adopting {
+		if err := addLegacyColumns(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+		return fmt.Errorf("error applying migration %d_%s: %w", m.Version, m.Name, err)
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+		m.Version, m.Name, m.Checksum, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error recording migration %d: %w", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration %d: %w", m.Version, err)
+	}
+	return nil
+}
+
+// addLegacyColumns adds the columns the first migration has on tables that
+// databases created before migrations may lack, as CREATE TABLE IF NOT
+// EXISTS leaves existing tables untouched.
+func addLegacyColumns(ctx context.Context, tx *sql.Tx) error {
+	var hasRole int
+	err := tx.QueryRowContext(ctx, `SELECT count(*) FROM pragma_table_info('users') WHERE name = 'role'`).Scan(&hasRole)
+	if err != nil {
+		return fmt.Errorf("error checking users table: %w", err)
+	}
+	if hasRole == 0 {
+		if _, err := tx.ExecContext(ctx, `ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'customer'`); err != nil {
+			return fmt.Errorf("error adding users.role: %w", err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown rolls back the last steps applied migrations, newest first.
+// It returns how many were rolled back.
+func (s *service) MigrateDown(ctx context.Context, steps int) (int, error) {
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := verifyChecksums(s.migrations, applied); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for i := len(s.migrations) - 1; i >= 0 && n < steps; i-- {
+		m := s.migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			return n, fmt.Errorf("%w: %d_%s", ErrNoDownMigration, m.Version, m.Name)
+		}
+		if err := s.revertMigration(ctx, m); err != nil {
+			return n, err
+		}
+		logging.FromContext(ctx).Info("Rolled back migration", "version", m.Version, "name", m.Name)
+		n++
+	}
+	return n, nil
+}
+
+func (s *service) revertMigration(ctx context.Context, m Migration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting rollback of migration %d: %w", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+		return fmt.Errorf("error rolling back migration %d_%s: %w", m.Version, m.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = ?`, m.Version); err != nil {
+		return fmt.Errorf("error unrecording migration %d: %w", m.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing rollback of migration %d: %w", m.Version, err)
+	}
+	return nil
+}
+
+// MigrationStatus lists every known migration and whether it was applied.
+// It fails when an applied migration was edited.
+func (s *service) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyChecksums(s.migrations, applied); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(s.migrations))
+	for _, m := range s.migrations {
+		a, ok := applied[m.Version]
+		statuses = append(statuses, MigrationStatus{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: a.appliedAt})
+	}
+	return statuses, nil
+}
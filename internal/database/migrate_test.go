@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatalf("error loading migrations. Err: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		t.Fatalf("expected migrations starting at 0001; got %+v", migrations)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("expected migration %d; got %d_%s", i+1, m.Version, m.Name)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadMigrationsRejectsBadFiles(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"bad name":   {"m/1_init.sql": {Data: []byte("x")}},
+		"down only":  {"m/0001_init.down.sql": {Data: []byte("x")}},
+		"name clash": {"m/0001_a.up.sql": {Data: []byte("x")}, "m/0001_b.down.sql": {Data: []byte("x")}},
+	} {
+		if _, err := loadMigrations(fsys, "m"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func testMigrations(t *testing.T, fsys fstest.MapFS) []Migration {
+	t.Helper()
+	migrations, err := loadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatalf("error loading migrations. Err: %v", err)
+	}
+	return migrations
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "m.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	fsys := fstest.MapFS{
+		"m/0001_coffee.up.sql":          {Data: []byte("CREATE TABLE coffee (id TEXT PRIMARY KEY);")},
+		"m/0001_coffee.down.sql":        {Data: []byte("DROP TABLE coffee;")},
+		"m/0002_coffee_origin.up.sql":   {Data: []byte("ALTER TABLE coffee ADD COLUMN origin TEXT;")},
+		"m/0002_coffee_origin.down.sql": {Data: []byte("ALTER TABLE coffee DROP COLUMN origin;")},
+	}
+	s := &service{db: db, migrations: testMigrations(t, fsys)}
+
+	if err := s.Ready(ctx); err == nil {
+		t.Error("expected an unmigrated database not to be ready")
+	}
+	if n, err := s.MigrateUp(ctx); err != nil || n != 2 {
+		t.Fatalf("expected 2 migrations applied; got %d, %v", n, err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO coffee (id, origin) VALUES ('1', 'Cusco')`); err != nil {
+		t.Fatalf("expected the migrated schema; got %v", err)
+	}
+	if n, err := s.MigrateUp(ctx); err != nil || n != 0 {
+		t.Errorf("expected nothing left to apply; got %d, %v", n, err)
+	}
+	if err := s.Ready(ctx); err != nil {
+		t.Errorf("expected a migrated database to be ready; got %v", err)
+	}
+
+	if n, err := s.MigrateDown(ctx, 1); err != nil || n != 1 {
+		t.Fatalf("expected 1 migration rolled back; got %d, %v", n, err)
+	}
+	statuses, err := s.MigrationStatus(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("expected only 0001 applied; got %+v", statuses)
+	}
+
+	// Editing an applied migration is refused
+	fsys["m/0001_coffee.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE coffee (id INTEGER PRIMARY KEY);")}
+	s.migrations = testMigrations(t, fsys)
+	if _, err := s.MigrateUp(ctx); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected a checksum mismatch; got %v", err)
+	}
+}
+
+func TestMigrateUpAdoptsExistingDatabase(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "m.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	// A database created by schema.sql before the role column existed
+	if _, err := db.ExecContext(ctx, `CREATE TABLE users (id TEXT PRIMARY KEY, email TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"m/0001_initial.up.sql": {Data: []byte(`CREATE TABLE IF NOT EXISTS users (id TEXT PRIMARY KEY, email TEXT NOT NULL, role TEXT NOT NULL DEFAULT 'customer');`)},
+	}
+	s := &service{db: db, migrations: testMigrations(t, fsys)}
+	if _, err := s.MigrateUp(ctx); err != nil {
+		t.Fatalf("error adopting database. Err: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO users (id, email, role) VALUES ('1', 'a@b.c', 'admin')`); err != nil {
+		t.Errorf("expected users.role to be added; got %v", err)
+	}
+}
+
+// migratedDB opens a new database with the embedded migrations applied.
+func migratedDB(t *testing.T) *service {
+	t.Helper()
+	db, err := sql.Open("sqlite", dsn(filepath.Join(t.TempDir(), "kaffino.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &service{db: db, q: New(db), migrations: migrations}
+	if _, err := s.MigrateUp(context.Background()); err != nil {
+		t.Fatalf("error applying the embedded migrations. Err: %v", err)
+	}
+	return s
+}
@@ -0,0 +1,25 @@
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS login_links;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS login_challenges;
+
+DROP TRIGGER IF EXISTS tags_search_update;
+DROP TRIGGER IF EXISTS product_tags_search_delete;
+DROP TRIGGER IF EXISTS product_tags_search_insert;
+DROP TRIGGER IF EXISTS products_search_delete;
+DROP TRIGGER IF EXISTS products_search_update;
+DROP TRIGGER IF EXISTS products_search_insert;
+DROP TABLE IF EXISTS product_search;
+
+DROP TABLE IF EXISTS inventory_movements;
+DROP TABLE IF EXISTS cart_items;
+DROP TABLE IF EXISTS carts;
+DROP TABLE IF EXISTS order_status_history;
+DROP TABLE IF EXISTS reviews;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS inventory;
+DROP TABLE IF EXISTS product_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS products;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,254 @@
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(36) PRIMARY KEY,
+    email VARCHAR(255) UNIQUE NOT NULL,
+    subscriber BOOLEAN DEFAULT FALSE,
+    username VARCHAR(255),
+    role TEXT NOT NULL DEFAULT 'customer',
+    created_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
+    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE IF NOT EXISTS products (
+    id VARCHAR(36) PRIMARY KEY,
+    code VARCHAR(255) UNIQUE NOT NULL,
+    images TEXT,
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    created_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
+    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(255) UNIQUE NOT NULL,
+    created_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
+    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP)
+);
+
+CREATE TABLE IF NOT EXISTS product_tags (
+    product_id VARCHAR(36) NOT NULL,
+    tag_id VARCHAR(36) NOT NULL,
+    PRIMARY KEY (product_id, tag_id),
+    FOREIGN KEY (product_id) REFERENCES products(id),
+    FOREIGN KEY (tag_id) REFERENCES tags(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_tags_product_id ON product_tags (product_id);
+CREATE INDEX IF NOT EXISTS idx_product_tags_tag_id ON product_tags (tag_id);
+
+CREATE TABLE IF NOT EXISTS inventory (
+    id VARCHAR(36) PRIMARY KEY,
+    product_id VARCHAR(36) NOT NULL,
+    stock INTEGER NOT NULL DEFAULT 0,
+    sizes TEXT,
+    price DECIMAL(10,2) NOT NULL DEFAULT 0.0,
+    created_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
+    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_product_id ON inventory (product_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_inventory_product_sizes ON inventory (product_id, sizes);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    order_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    total_amount DECIMAL(10, 2) NOT NULL,
+    shipping_address TEXT,
+    billing_address TEXT,
+    payment_method VARCHAR(255),
+    order_status TEXT DEFAULT 'Pending',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders (user_id);
+
+CREATE TABLE IF NOT EXISTS order_items (
+    id VARCHAR(36) PRIMARY KEY,
+    order_id VARCHAR(36) NOT NULL,
+    product_id VARCHAR(36) NOT NULL,
+    quantity INTEGER NOT NULL,
+    price DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items (order_id);
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id VARCHAR(36) PRIMARY KEY,
+    product_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    rating INTEGER NOT NULL,
+    comment TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (product_id) REFERENCES products(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reviews_product_id ON reviews (product_id);
+CREATE INDEX IF NOT EXISTS idx_reviews_user_id ON reviews (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reviews_product_user ON reviews (product_id, user_id);
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id VARCHAR(36) PRIMARY KEY,
+    order_id VARCHAR(36) NOT NULL,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    changed_by VARCHAR(36) NOT NULL,
+    note TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order_id ON order_status_history (order_id);
+
+CREATE TABLE IF NOT EXISTS carts (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(255) UNIQUE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS cart_items (
+    id VARCHAR(36) PRIMARY KEY,
+    cart_id VARCHAR(36) NOT NULL,
+    product_id VARCHAR(36) NOT NULL,
+    size TEXT NOT NULL DEFAULT '',
+    schedule TEXT NOT NULL DEFAULT '',
+    quantity INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (cart_id, product_id, size, schedule),
+    FOREIGN KEY (cart_id) REFERENCES carts(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE TABLE IF NOT EXISTS inventory_movements (
+    id VARCHAR(36) PRIMARY KEY,
+    inventory_id VARCHAR(36) NOT NULL,
+    order_id VARCHAR(36),
+    quantity INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (inventory_id) REFERENCES inventory(id),
+    FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_inventory_id ON inventory_movements (inventory_id);
+CREATE INDEX IF NOT EXISTS idx_inventory_movements_order_id ON inventory_movements (order_id);
+
+-- Full-text index over the catalog. It needs SQLite built with FTS5, which
+-- for github.com/mattn/go-sqlite3 means building with -tags sqlite_fts5.
+CREATE VIRTUAL TABLE IF NOT EXISTS product_search USING fts5(
+    product_id UNINDEXED,
+    title,
+    description,
+    code,
+    tags,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS products_search_insert AFTER INSERT ON products BEGIN
+    INSERT INTO product_search (product_id, title, description, code, tags)
+    VALUES (new.id, new.title, COALESCE(new.description, ''), new.code, '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS products_search_update AFTER UPDATE ON products BEGIN
+    UPDATE product_search
+    SET title = new.title, description = COALESCE(new.description, ''), code = new.code
+    WHERE product_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS products_search_delete AFTER DELETE ON products BEGIN
+    DELETE FROM product_search WHERE product_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS product_tags_search_insert AFTER INSERT ON product_tags BEGIN
+    UPDATE product_search
+    SET tags = (
+        SELECT COALESCE(group_concat(t.name, ' '), '')
+        FROM product_tags pt
+        JOIN tags t ON t.id = pt.tag_id
+        WHERE pt.product_id = new.product_id
+    )
+    WHERE product_id = new.product_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS product_tags_search_delete AFTER DELETE ON product_tags BEGIN
+    UPDATE product_search
+    SET tags = (
+        SELECT COALESCE(group_concat(t.name, ' '), '')
+        FROM product_tags pt
+        JOIN tags t ON t.id = pt.tag_id
+        WHERE pt.product_id = old.product_id
+    )
+    WHERE product_id = old.product_id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS tags_search_update AFTER UPDATE OF name ON tags BEGIN
+    UPDATE product_search
+    SET tags = (
+        SELECT COALESCE(group_concat(t.name, ' '), '')
+        FROM product_tags pt
+        JOIN tags t ON t.id = pt.tag_id
+        WHERE pt.product_id = product_search.product_id
+    )
+    WHERE product_id IN (SELECT product_id FROM product_tags WHERE tag_id = new.id);
+END;
+
+-- Index products that existed before the search table did
+INSERT INTO product_search (product_id, title, description, code, tags)
+SELECT p.id, p.title, COALESCE(p.description, ''), p.code, COALESCE((
+    SELECT group_concat(t.name, ' ')
+    FROM product_tags pt
+    JOIN tags t ON t.id = pt.tag_id
+    WHERE pt.product_id = p.id
+), '')
+FROM products p
+WHERE p.id NOT IN (SELECT product_id FROM product_search);
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+    email VARCHAR(255) PRIMARY KEY,
+    code_hash TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_challenges_expires_at ON login_challenges (expires_at);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    email VARCHAR(255) PRIMARY KEY,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP,
+    last_attempt TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS login_links (
+    token_hash TEXT PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_links_expires_at ON login_links (expires_at);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id TEXT PRIMARY KEY,
+    user_id VARCHAR(36),
+    data BLOB NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions (expires_at);
@@ -0,0 +1,26 @@
+CREATE TABLE payments (
+    id VARCHAR(36) PRIMARY KEY,
+    order_id VARCHAR(36) NOT NULL,
+    provider TEXT NOT NULL,
+    intent_id TEXT NOT NULL,
+    client_secret TEXT NOT NULL DEFAULT '',
+    amount INTEGER NOT NULL,
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (order_id) REFERENCES orders(id),
+    UNIQUE (provider, intent_id)
+);
+
+CREATE INDEX idx_payments_order_id ON payments (order_id);
+
+-- Webhook events already handled, so redeliveries are ignored
+CREATE TABLE payment_events (
+    provider TEXT NOT NULL,
+    id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    intent_id TEXT NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (provider, id)
+);
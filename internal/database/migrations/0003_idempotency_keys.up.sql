@@ -0,0 +1,15 @@
+-- Responses of POST requests sent with an Idempotency-Key header, so
+-- retries get the original response instead of repeating the request.
+-- status_code is NULL while the first request is still running.
+CREATE TABLE idempotency_keys (
+    user_id VARCHAR(36) NOT NULL,
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INTEGER,
+    content_type TEXT NOT NULL DEFAULT '',
+    response_body BLOB,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys (created_at);
@@ -0,0 +1,23 @@
+-- Recurring deliveries of a product variant. The scheduler places an order
+-- when next_run_at comes, after emailing a reminder shortly before.
+CREATE TABLE subscriptions (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    inventory_id VARCHAR(36) NOT NULL,
+    quantity INTEGER NOT NULL,
+    cadence TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    next_run_at TIMESTAMP NOT NULL,
+    reminder_sent BOOLEAN NOT NULL DEFAULT FALSE,
+    shipping_address TEXT,
+    billing_address TEXT,
+    payment_method VARCHAR(255),
+    last_order_id VARCHAR(36),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (inventory_id) REFERENCES inventory(id)
+);
+
+CREATE INDEX idx_subscriptions_user_id ON subscriptions (user_id);
+CREATE INDEX idx_subscriptions_next_run_at ON subscriptions (status, next_run_at);
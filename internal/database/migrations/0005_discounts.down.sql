@@ -0,0 +1,9 @@
+ALTER TABLE order_items DROP COLUMN discount_amount;
+ALTER TABLE orders DROP COLUMN discount_code;
+ALTER TABLE orders DROP COLUMN shipping_amount;
+ALTER TABLE orders DROP COLUMN discount_amount;
+ALTER TABLE orders DROP COLUMN subtotal_amount;
+DROP TABLE IF EXISTS discount_redemptions;
+DROP TABLE IF EXISTS discount_tags;
+DROP TABLE IF EXISTS discount_products;
+DROP TABLE IF EXISTS discounts;
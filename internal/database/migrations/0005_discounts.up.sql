@@ -0,0 +1,60 @@
+-- Discount codes run by marketing. A code takes a percentage or a fixed
+-- amount off the eligible items, or waives shipping. Empty limits mean
+-- unlimited; a code with products or tags only applies to those items.
+CREATE TABLE discounts (
+    id VARCHAR(36) PRIMARY KEY,
+    code VARCHAR(64) UNIQUE NOT NULL,
+    description TEXT,
+    kind TEXT NOT NULL,
+    value DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    min_order_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP,
+    ends_at TIMESTAMP,
+    max_uses INTEGER,
+    max_uses_per_user INTEGER,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE discount_products (
+    discount_id VARCHAR(36) NOT NULL,
+    product_id VARCHAR(36) NOT NULL,
+    PRIMARY KEY (discount_id, product_id),
+    FOREIGN KEY (discount_id) REFERENCES discounts(id),
+    FOREIGN KEY (product_id) REFERENCES products(id)
+);
+
+CREATE TABLE discount_tags (
+    discount_id VARCHAR(36) NOT NULL,
+    tag_id VARCHAR(36) NOT NULL,
+    PRIMARY KEY (discount_id, tag_id),
+    FOREIGN KEY (discount_id) REFERENCES discounts(id),
+    FOREIGN KEY (tag_id) REFERENCES tags(id)
+);
+
+-- One row per order placed with a code. Usage limits count the rows whose
+-- order was not cancelled or refunded.
+CREATE TABLE discount_redemptions (
+    id VARCHAR(36) PRIMARY KEY,
+    discount_id VARCHAR(36) NOT NULL,
+    order_id VARCHAR(36) NOT NULL UNIQUE,
+    user_id VARCHAR(36) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (discount_id) REFERENCES discounts(id),
+    FOREIGN KEY (order_id) REFERENCES orders(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_discount_redemptions_discount_id ON discount_redemptions (discount_id, user_id);
+
+-- Orders keep the breakdown of their total: total_amount is
+-- subtotal_amount - discount_amount + shipping_amount.
+ALTER TABLE orders ADD COLUMN subtotal_amount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN discount_amount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN shipping_amount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN discount_code VARCHAR(64);
+ALTER TABLE order_items ADD COLUMN discount_amount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+UPDATE orders SET subtotal_amount = total_amount;
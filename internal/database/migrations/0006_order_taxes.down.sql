@@ -0,0 +1,4 @@
+ALTER TABLE order_items DROP COLUMN tax_amount;
+ALTER TABLE orders DROP COLUMN prices_include_tax;
+ALTER TABLE orders DROP COLUMN tax_rate;
+ALTER TABLE orders DROP COLUMN tax_amount;
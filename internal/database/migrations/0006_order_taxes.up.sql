@@ -0,0 +1,6 @@
+-- Orders keep the IGV charged on each item and in total, and the rule it
+-- was worked out with. Orders placed before have no tax_rate.
+ALTER TABLE orders ADD COLUMN tax_amount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN tax_rate DECIMAL(5, 4);
+ALTER TABLE orders ADD COLUMN prices_include_tax BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE order_items ADD COLUMN tax_amount DECIMAL(10, 2) NOT NULL DEFAULT 0;
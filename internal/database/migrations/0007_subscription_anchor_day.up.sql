@@ -0,0 +1,4 @@
+-- Monthly subscriptions run on the day of the month they started on, or the
+-- last day of shorter months. Existing ones keep the day of their next run.
+ALTER TABLE subscriptions ADD COLUMN anchor_day INTEGER NOT NULL DEFAULT 0;
+UPDATE subscriptions SET anchor_day = CAST(substr(next_run_at, 9, 2) AS INTEGER);
@@ -0,0 +1,13 @@
+-- Order items keep the variant they were ordered in. Items placed before
+-- get it from the stock their order reserved.
+ALTER TABLE order_items ADD COLUMN inventory_id VARCHAR(36);
+ALTER TABLE order_items ADD COLUMN size TEXT;
+UPDATE order_items SET inventory_id = (
+    SELECT m.inventory_id
+    FROM inventory_movements m
+    JOIN inventory i ON i.id = m.inventory_id
+    WHERE m.order_id = order_items.order_id AND m.reason = 'reserve'
+        AND i.product_id = order_items.product_id AND m.quantity = -order_items.quantity
+    LIMIT 1
+);
+UPDATE order_items SET size = (SELECT sizes FROM inventory WHERE id = order_items.inventory_id);
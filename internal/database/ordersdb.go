@@ -0,0 +1,363 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+
+	"kaffino/internal/tax"
+)
+
+var (
+	// ErrOrderNotFound is returned when an order does not exist.
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrInvalidOrder is returned when an order request cannot be fulfilled as sent.
+	ErrInvalidOrder = errors.New("invalid order")
+)
+
+// OrderItemInput is a single line of an order as requested by the client.
+type OrderItemInput struct {
+	ProductID string
+	// Size picks the variant of the product. It may be left out for
+	// products that come in a single variant.
+	Size string
+	// VariantID, when set, picks the variant instead of Size.
+	VariantID string
+	Quantity  int64
+}
+
+// Order is a placed order.
+type Order struct {
+	ID               string
+	UserID           string
+	OrderDate        sql.NullTime
+	TotalAmount      float64
+	ShippingAddress  sql.NullString
+	BillingAddress   sql.NullString
+	PaymentMethod    sql.NullString
+	OrderStatus      sql.NullString
+	CreatedAt        sql.NullTime
+	UpdatedAt        sql.NullTime
+	SubtotalAmount   float64
+	DiscountAmount   float64
+	ShippingAmount   float64
+	DiscountCode     sql.NullString
+	TaxAmount        float64
+	TaxRate          sql.NullFloat64
+	PricesIncludeTax bool
+}
+
+// OrderItem is a line of an order.
+type OrderItem struct {
+	ID             string
+	OrderID        string
+	ProductID      string
+	Quantity       int64
+	Price          float64
+	CreatedAt      sql.NullTime
+	UpdatedAt      sql.NullTime
+	DiscountAmount float64
+	TaxAmount      float64
+	// InventoryID and Size are the variant ordered. Items of orders placed
+	// before they were recorded may lack them.
+	InventoryID sql.NullString
+	Size        sql.NullString
+}
+
+// Charges are what the shop adds to, or carves out of, the catalog prices
+// of an order.
+type Charges struct {
+	// Shipping is the fee charged for delivering the order.
+	Shipping float64
+	// Tax says how IGV applies to the prices and the shipping fee.
+	Tax tax.Rule
+}
+
+// OrderInput holds everything needed to place an order. Prices are never
+// taken from the client, they are looked up in the inventory table.
+type OrderInput struct {
+	ShippingAddress string
+	BillingAddress  string
+	PaymentMethod   string
+	Items           []OrderItemInput
+	Charges
+	// DiscountCode, when set, must apply to the order or it is refused with
+	// ErrDiscountNotApplicable.
+	DiscountCode string
+}
+
+// OrderDetails is an order together with its items.
+type OrderDetails struct {
+	Order
+	Items []OrderItem
+}
+
+// CreateOrder creates an order and its items in a single transaction.
+// The total amount is computed from the current inventory prices, less the
+// discount code if any, plus shipping and, when prices do not include it,
+// tax. The ordered units are reserved so the order fails with
+// ErrInsufficientStock instead of overselling.
+func (s *service) CreateOrder(ctx context.Context, userID string, input OrderInput) (*OrderDetails, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	order, err := createOrder(ctx, tx, userID, input, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing order: %w", err)
+	}
+
+	return order, nil
+}
+
+// createOrder creates an order inside a transaction.
+func createOrder(ctx context.Context, tx *sql.Tx, userID string, input OrderInput, now time.Time) (*OrderDetails, error) {
+	if len(input.Items) == 0 {
+		return nil, fmt.Errorf("%w: order has no items", ErrInvalidOrder)
+	}
+
+	order := &OrderDetails{
+		Order: Order{
+			ID:              uuid.New().String(),
+			UserID:          userID,
+			OrderDate:       sql.NullTime{Time: now, Valid: true},
+			ShippingAddress: nullString(input.ShippingAddress),
+			BillingAddress:  nullString(input.BillingAddress),
+			PaymentMethod:   nullString(input.PaymentMethod),
+			OrderStatus:     sql.NullString{String: string(OrderPending), Valid: true},
+			CreatedAt:       sql.NullTime{Time: now, Valid: true},
+			UpdatedAt:       sql.NullTime{Time: now, Valid: true},
+		},
+	}
+
+	var subtotal float64
+	for _, in := range input.Items {
+		if in.ProductID == "" || in.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: each item needs a product and a positive quantity", ErrInvalidOrder)
+		}
+
+		variant, err := reserveStock(ctx, tx, order.ID, in)
+		if err != nil {
+			return nil, err
+		}
+
+		order.Items = append(order.Items, OrderItem{
+			ID:          uuid.New().String(),
+			OrderID:     order.ID,
+			ProductID:   in.ProductID,
+			Quantity:    in.Quantity,
+			Price:       variant.Price,
+			CreatedAt:   sql.NullTime{Time: now, Valid: true},
+			UpdatedAt:   sql.NullTime{Time: now, Valid: true},
+			InventoryID: sql.NullString{String: variant.ID, Valid: true},
+			Size:        variant.Sizes,
+		})
+		subtotal += variant.Price * float64(in.Quantity)
+	}
+	order.SubtotalAmount = roundCents(subtotal)
+	order.ShippingAmount = roundCents(input.Shipping)
+
+	// The discount is checked after the stock is reserved, which holds the
+	// write lock, so concurrent orders cannot overrun its usage limits
+	var discount *Discount
+	if input.DiscountCode != "" {
+		var err error
+		discount, err = applyDiscount(ctx, tx, order, input.DiscountCode, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	applyTax(order, input.Tax)
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO orders (id, user_id, order_date, total_amount, subtotal_amount, discount_amount, shipping_amount,
+			discount_code, tax_amount, tax_rate, prices_include_tax, shipping_address, billing_address, payment_method,
+			order_status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, order.ID, order.UserID, order.OrderDate, order.TotalAmount, order.SubtotalAmount, order.DiscountAmount,
+		order.ShippingAmount, order.DiscountCode, order.TaxAmount, order.TaxRate, order.PricesIncludeTax,
+		order.ShippingAddress, order.BillingAddress, order.PaymentMethod, order.OrderStatus, order.CreatedAt,
+		order.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error creating order: %w", err)
+	}
+
+	for _, item := range order.Items {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO order_items (id, order_id, product_id, inventory_id, size, quantity, price, discount_amount,
+				tax_amount, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, item.ID, item.OrderID, item.ProductID, item.InventoryID, item.Size, item.Quantity, item.Price,
+			item.DiscountAmount, item.TaxAmount, item.CreatedAt, item.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error creating order item: %w", err)
+		}
+	}
+
+	if discount != nil {
+		if err := redeemDiscount(ctx, tx, discount.ID, order, now); err != nil {
+			return nil, err
+		}
+	}
+
+	err = recordOrderStatus(ctx, tx, order.ID, sql.NullString{}, OrderPending, userID, "", now)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// applyTax works out the tax of each item, on its amount after discount,
+// and of the shipping, then sets the tax and the total of the order.
+func applyTax(order *OrderDetails, rule tax.Rule) {
+	var itemsDiscount, itemsTax float64
+	for i := range order.Items {
+		item := &order.Items[i]
+		_, item.TaxAmount = rule.Split(roundCents(item.Price*float64(item.Quantity) - item.DiscountAmount))
+		itemsDiscount += item.DiscountAmount
+		itemsTax += item.TaxAmount
+	}
+	// Whatever the discount did not take off the items it took off shipping
+	_, shippingTax := rule.Split(roundCents(order.ShippingAmount - (order.DiscountAmount - itemsDiscount)))
+
+	order.TaxAmount = roundCents(itemsTax + shippingTax)
+	order.TaxRate = sql.NullFloat64{Float64: rule.Rate, Valid: true}
+	order.PricesIncludeTax = rule.Included
+	order.TotalAmount = roundCents(order.SubtotalAmount - order.DiscountAmount + order.ShippingAmount)
+	if !rule.Included {
+		order.TotalAmount = roundCents(order.TotalAmount + order.TaxAmount)
+	}
+}
+
+// GetOrder retrieves an order and its items by ID.
+func (s *service) GetOrder(ctx context.Context, id string) (*OrderDetails, error) {
+	order := &OrderDetails{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, order_date, total_amount, subtotal_amount, discount_amount, shipping_amount, discount_code,
+			tax_amount, tax_rate, prices_include_tax, shipping_address, billing_address, payment_method, order_status, created_at, updated_at
+		FROM orders
+		WHERE id = ?
+	`, id).Scan(&order.ID, &order.UserID, &order.OrderDate, &order.TotalAmount, &order.SubtotalAmount,
+		&order.DiscountAmount, &order.ShippingAmount, &order.DiscountCode, &order.TaxAmount, &order.TaxRate,
+		&order.PricesIncludeTax, &order.ShippingAddress, &order.BillingAddress, &order.PaymentMethod, &order.OrderStatus,
+		&order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("error getting order: %w", err)
+	}
+
+	order.Items, err = orderItems(ctx, s.db, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return order, nil
+}
+
+// ListOrders retrieves all orders placed by a user, newest first.
+func (s *service) ListOrders(ctx context.Context, userID string) ([]*OrderDetails, error) {
+	return s.listOrders(ctx, `WHERE user_id = ?`, userID)
+}
+
+// ListAllOrders retrieves the orders of every user, newest first, only
+// those in status when it is set.
+func (s *service) ListAllOrders(ctx context.Context, status OrderStatus) ([]*OrderDetails, error) {
+	if status != "" {
+		return s.listOrders(ctx, `WHERE order_status = ?`, string(status))
+	}
+	return s.listOrders(ctx, ``)
+}
+
+func (s *service) listOrders(ctx context.Context, where string, args ...interface{}) ([]*OrderDetails, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, order_date, total_amount, subtotal_amount, discount_amount, shipping_amount, discount_code,
+			tax_amount, tax_rate, prices_include_tax, shipping_address, billing_address, payment_method, order_status, created_at, updated_at
+		FROM orders
+		`+where+`
+		ORDER BY order_date DESC
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []*OrderDetails
+	for rows.Next() {
+		order := &OrderDetails{}
+		err := rows.Scan(&order.ID, &order.UserID, &order.OrderDate, &order.TotalAmount, &order.SubtotalAmount,
+			&order.DiscountAmount, &order.ShippingAmount, &order.DiscountCode, &order.TaxAmount, &order.TaxRate,
+			&order.PricesIncludeTax, &order.ShippingAddress, &order.BillingAddress, &order.PaymentMethod,
+			&order.OrderStatus, &order.CreatedAt, &order.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning order: %w", err)
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating orders: %w", err)
+	}
+
+	for _, order := range orders {
+		order.Items, err = orderItems(ctx, s.db, order.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return orders, nil
+}
+
+// orderItems retrieves the items of an order.
+func orderItems(ctx context.Context, db DBTX, orderID string) ([]OrderItem, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, order_id, product_id, inventory_id, size, quantity, price, discount_amount, tax_amount, created_at,
+			updated_at
+		FROM order_items
+		WHERE order_id = ?
+		ORDER BY created_at, rowid
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing order items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []OrderItem
+	for rows.Next() {
+		var item OrderItem
+		err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.InventoryID, &item.Size, &item.Quantity,
+			&item.Price, &item.DiscountAmount, &item.TaxAmount, &item.CreatedAt, &item.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning order item: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating order items: %w", err)
+	}
+
+	return items, nil
+}
+
+// nullString converts an empty string to a NULL value.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// roundCents rounds an amount to two decimal places.
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
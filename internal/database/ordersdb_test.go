@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"kaffino/internal/tax"
+)
+
+func TestApplyTax(t *testing.T) {
+	newOrder := func() *OrderDetails {
+		return &OrderDetails{
+			Order: Order{SubtotalAmount: 45, DiscountAmount: 12.5, ShippingAmount: 8},
+			Items: []OrderItem{
+				{Price: 15, Quantity: 2, DiscountAmount: 3},
+				{Price: 15, Quantity: 1, DiscountAmount: 1.5},
+			},
+		}
+	}
+
+	// Prices include IGV: the tax is carved out and the total is unchanged.
+	// The 8.00 of the discount not on the items waived the shipping.
+	order := newOrder()
+	applyTax(order, tax.IGV)
+	if order.Items[0].TaxAmount != 4.12 || order.Items[1].TaxAmount != 2.06 {
+		t.Errorf("got item taxes %v and %v; want 4.12 and 2.06", order.Items[0].TaxAmount, order.Items[1].TaxAmount)
+	}
+	if order.TaxAmount != 6.18 || order.TotalAmount != 40.5 {
+		t.Errorf("got tax %v and total %v; want 6.18 and 40.5", order.TaxAmount, order.TotalAmount)
+	}
+	if !order.PricesIncludeTax || order.TaxRate.Float64 != tax.IGVRate {
+		t.Errorf("expected the tax rule to be recorded; got %v, %v", order.PricesIncludeTax, order.TaxRate)
+	}
+
+	// Prices before IGV: the tax is added to the total.
+	order = newOrder()
+	order.DiscountAmount = 4.5
+	applyTax(order, tax.Rule{Rate: tax.IGVRate})
+	if order.TaxAmount != 8.73 || order.TotalAmount != 57.23 {
+		t.Errorf("got tax %v and total %v; want 8.73 and 57.23", order.TaxAmount, order.TotalAmount)
+	}
+}
+
+// orderFixture adds a product with the given variant sizes, each with 10
+// units at 20.00, and a customer to a migrated database.
+func orderFixture(t *testing.T, s *service, sizes ...string) (productID, userID string) {
+	t.Helper()
+	ctx := context.Background()
+	product := &Product{ID: "p1", Code: "CH-01", Title: "Chanchamayo"}
+	if err := s.CreateProduct(ctx, product); err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range sizes {
+		variant := &Inventory{ProductID: product.ID, Stock: 10, Sizes: sql.NullString{String: size, Valid: true}, Price: 20}
+		if err := s.CreateVariant(ctx, variant); err != nil {
+			t.Fatal(err)
+		}
+	}
+	userID, err := s.GetUserID(ctx, "ana@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return product.ID, userID
+}
+
+func TestExpirePendingOrdersKeepsOrdersBeingPaid(t *testing.T) {
+	s := migratedDB(t)
+	ctx := context.Background()
+	productID, userID := orderFixture(t, s, "Full Bag (12oz)")
+
+	place := func() *OrderDetails {
+		order, err := s.CreateOrder(ctx, userID, OrderInput{Items: []OrderItemInput{{ProductID: productID, Quantity: 1}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return order
+	}
+	paying, abandoned := place(), place()
+	payment := &Payment{OrderID: paying.ID, Provider: "fake", IntentID: "pi_1", Amount: 2000, Currency: "PEN", Status: PaymentRequiresCapture}
+	if err := s.CreatePayment(ctx, payment); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.ExpirePendingOrders(ctx, time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("expected only the abandoned order to expire; got %d, %v", n, err)
+	}
+	if order, _ := s.GetOrder(ctx, abandoned.ID); order.OrderStatus.String != string(OrderCancelled) {
+		t.Errorf("expected the abandoned order to be cancelled; got %s", order.OrderStatus.String)
+	}
+
+	order, err := s.ApplyPaymentEvent(ctx, PaymentEventInput{
+		Provider: "fake", EventID: "evt_1", Type: "payment.succeeded", IntentID: "pi_1",
+		Status: "succeeded", OrderStatus: OrderPaid,
+	})
+	if err != nil {
+		t.Fatalf("error applying payment. Err: %v", err)
+	}
+	if order.OrderStatus.String != string(OrderPaid) {
+		t.Errorf("expected the order being paid to become Paid; got %s", order.OrderStatus.String)
+	}
+}
+
+func TestCreateOrderRecordsVariant(t *testing.T) {
+	s := migratedDB(t)
+	ctx := context.Background()
+	productID, userID := orderFixture(t, s, "Full Bag (12oz)", "Half Bag (6oz)")
+
+	for _, size := range []string{"", "Tote Bag"} {
+		_, err := s.CreateOrder(ctx, userID, OrderInput{Items: []OrderItemInput{{ProductID: productID, Size: size, Quantity: 1}}})
+		if !errors.Is(err, ErrInvalidOrder) {
+			t.Errorf("expected size %q to be refused; got %v", size, err)
+		}
+	}
+
+	placed, err := s.CreateOrder(ctx, userID, OrderInput{Items: []OrderItemInput{
+		{ProductID: productID, Size: "Full Bag (12oz)", Quantity: 1},
+		{ProductID: productID, Size: "Half Bag (6oz)", Quantity: 2},
+	}})
+	if err != nil {
+		t.Fatalf("error creating order. Err: %v", err)
+	}
+	order, err := s.GetOrder(ctx, placed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Items) != 2 || order.Items[0].Size.String != "Full Bag (12oz)" || order.Items[1].Size.String != "Half Bag (6oz)" {
+		t.Fatalf("expected both sizes to be recorded; got %+v", order.Items)
+	}
+	if !order.Items[0].InventoryID.Valid || order.Items[0].InventoryID == order.Items[1].InventoryID {
+		t.Errorf("expected each item to keep its own variant; got %+v", order.Items)
+	}
+}
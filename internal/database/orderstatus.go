@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OrderStatusHistory records a change of an order's status.
+type OrderStatusHistory struct {
+	ID         string
+	OrderID    string
+	FromStatus sql.NullString
+	ToStatus   string
+	ChangedBy  string
+	Note       sql.NullString
+	CreatedAt  sql.NullTime
+}
+
+// OrderStatus is a step in the lifecycle of an order.
+type OrderStatus string
+
+const (
+	OrderPending   OrderStatus = "Pending"
+	OrderPaid      OrderStatus = "Paid"
+	OrderRoasting  OrderStatus = "Roasting"
+	OrderShipped   OrderStatus = "Shipped"
+	OrderDelivered OrderStatus = "Delivered"
+	OrderCancelled OrderStatus = "Cancelled"
+	OrderRefunded  OrderStatus = "Refunded"
+)
+
+// ErrInvalidTransition is returned when an order cannot move to the requested status.
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// orderTransitions lists the statuses each status may move to.
+// Cancelled and Refunded are final.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPending:   {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderRoasting, OrderRefunded},
+	OrderRoasting:  {OrderShipped, OrderRefunded},
+	OrderShipped:   {OrderDelivered},
+	OrderDelivered: {OrderRefunded},
+}
+
+// ParseOrderStatus validates a status name.
+func ParseOrderStatus(s string) (OrderStatus, error) {
+	switch status := OrderStatus(s); status {
+	case OrderPending, OrderPaid, OrderRoasting, OrderShipped, OrderDelivered, OrderCancelled, OrderRefunded:
+		return status, nil
+	}
+	return "", fmt.Errorf("unknown order status %q", s)
+}
+
+// CanTransition reports whether an order in status from may move to status to.
+func CanTransition(from, to OrderStatus) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateOrderStatus moves an order to a new status and records the change in
+// the order's history. It returns ErrInvalidTransition if the lifecycle does
+// not allow the move.
+func (s *service) UpdateOrderStatus(ctx context.Context, orderID string, status OrderStatus, changedBy, note string) (*OrderDetails, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := setOrderStatus(ctx, tx, orderID, status, changedBy, note); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing order status: %w", err)
+	}
+
+	return s.GetOrder(ctx, orderID)
+}
+
+// setOrderStatus performs a checked status transition inside a transaction.
+func setOrderStatus(ctx context.Context, tx *sql.Tx, orderID string, status OrderStatus, changedBy, note string) error {
+	var current sql.NullString
+	err := tx.QueryRowContext(ctx, `SELECT order_status FROM orders WHERE id = ?`, orderID).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrOrderNotFound
+		}
+		return fmt.Errorf("error getting order status: %w", err)
+	}
+
+	from := OrderStatus(current.String)
+	if !CanTransition(from, status) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, status)
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET order_status = ?, updated_at = ?
+		WHERE id = ?
+	`, string(status), now, orderID)
+	if err != nil {
+		return fmt.Errorf("error updating order status: %w", err)
+	}
+
+	if status == OrderCancelled {
+		if err := releaseStock(ctx, tx, orderID); err != nil {
+			return err
+		}
+	}
+
+	return recordOrderStatus(ctx, tx, orderID, current, status, changedBy, note, now)
+}
+
+// recordOrderStatus appends an entry to the order status history.
+func recordOrderStatus(ctx context.Context, db DBTX, orderID string, from sql.NullString, to OrderStatus, changedBy, note string, at time.Time) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO order_status_history (id, order_id, from_status, to_status, changed_by, note, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, uuid.New().String(), orderID, from, string(to), changedBy, nullString(note), at)
+	if err != nil {
+		return fmt.Errorf("error recording order status: %w", err)
+	}
+	return nil
+}
+
+// GetOrderStatusHistory lists the status changes of an order, oldest first.
+func (s *service) GetOrderStatusHistory(ctx context.Context, orderID string) ([]OrderStatusHistory, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, order_id, from_status, to_status, changed_by, note, created_at
+		FROM order_status_history
+		WHERE order_id = ?
+		ORDER BY created_at, rowid
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing order status history: %w", err)
+	}
+	defer rows.Close()
+
+	var history []OrderStatusHistory
+	for rows.Next() {
+		var h OrderStatusHistory
+		err := rows.Scan(&h.ID, &h.OrderID, &h.FromStatus, &h.ToStatus, &h.ChangedBy, &h.Note, &h.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning order status history: %w", err)
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating order status history: %w", err)
+	}
+
+	return history, nil
+}
@@ -0,0 +1,37 @@
+package database
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to OrderStatus
+		want     bool
+	}{
+		{OrderPending, OrderPaid, true},
+		{OrderPending, OrderCancelled, true},
+		{OrderPaid, OrderRoasting, true},
+		{OrderRoasting, OrderShipped, true},
+		{OrderShipped, OrderDelivered, true},
+		{OrderDelivered, OrderRefunded, true},
+		{OrderPending, OrderShipped, false},
+		{OrderPaid, OrderPending, false},
+		{OrderShipped, OrderCancelled, false},
+		{OrderCancelled, OrderPaid, false},
+		{OrderRefunded, OrderPaid, false},
+		{OrderStatus("Lost"), OrderPaid, false},
+	}
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%s, %s) = %v; want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestParseOrderStatus(t *testing.T) {
+	if _, err := ParseOrderStatus("Roasting"); err != nil {
+		t.Errorf("expected Roasting to be valid; got %v", err)
+	}
+	if _, err := ParseOrderStatus("roasting"); err == nil {
+		t.Error("expected lowercase status to be rejected")
+	}
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrPaymentNotFound is returned when a payment does not exist.
+	ErrPaymentNotFound = errors.New("payment not found")
+	// ErrDuplicatePaymentEvent is returned when a payment event was already
+	// handled.
+	ErrDuplicatePaymentEvent = errors.New("payment event already handled")
+)
+
+// PaymentRequiresCapture is the status of a payment whose intent is
+// waiting to be captured.
+const PaymentRequiresCapture = "requires_capture"
+
+// PaymentEventInput is a provider notification about a payment, already
+// translated to the statuses it sets.
+type PaymentEventInput struct {
+	Provider string
+	EventID  string
+	Type     string
+	IntentID string
+	// Status is the new status of the payment.
+	Status string
+	// OrderStatus is the status the order moves to, if any.
+	OrderStatus OrderStatus
+}
+
+// Payment is the payment intent an order is paid with.
+type Payment struct {
+	ID           string
+	OrderID      string
+	Provider     string
+	IntentID     string
+	ClientSecret string
+	Amount       int64
+	Currency     string
+	Status       string
+	CreatedAt    sql.NullTime
+	UpdatedAt    sql.NullTime
+}
+
+// CreatePayment stores a payment started with a provider.
+func (s *service) CreatePayment(ctx context.Context, payment *Payment) error {
+	now := time.Now()
+	payment.ID = uuid.New().String()
+	payment.CreatedAt = sql.NullTime{Time: now, Valid: true}
+	payment.UpdatedAt = sql.NullTime{Time: now, Valid: true}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO payments (id, order_id, provider, intent_id, client_secret, amount, currency, status, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, payment.ID, payment.OrderID, payment.Provider, payment.IntentID, payment.ClientSecret, payment.Amount,
+		payment.Currency, payment.Status, payment.CreatedAt, payment.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("error creating payment: %w", err)
+	}
+	return nil
+}
+
+// GetOrderPayment retrieves the latest payment of an order.
+func (s *service) GetOrderPayment(ctx context.Context, orderID string) (*Payment, error) {
+	payment := &Payment{}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, order_id, provider, intent_id, client_secret, amount, currency, status, created_at, updated_at
+		FROM payments
+		WHERE order_id = ?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT 1
+	`, orderID).Scan(&payment.ID, &payment.OrderID, &payment.Provider, &payment.IntentID, &payment.ClientSecret,
+		&payment.Amount, &payment.Currency, &payment.Status, &payment.CreatedAt, &payment.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("error getting payment: %w", err)
+	}
+	return payment, nil
+}
+
+// SetPaymentStatus changes the status of a payment, for changes the
+// provider does not report through webhooks.
+func (s *service) SetPaymentStatus(ctx context.Context, id, status string) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE payments SET status = ?, updated_at = ? WHERE id = ?
+	`, status, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("error updating payment: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("error updating payment: %w", err)
+	} else if n == 0 {
+		return ErrPaymentNotFound
+	}
+	return nil
+}
+
+// ApplyPaymentEvent records a payment event, updates the payment and moves
+// its order to the event's order status. Each event is applied once: a
+// redelivered event returns ErrDuplicatePaymentEvent, and an order already
+// in the target status is left alone. When the lifecycle does not allow the
+// move, such as for an order cancelled before it was paid, the payment is
+// still updated and the order keeps its status; callers compare the
+// returned order with the status they asked for.
+func (s *service) ApplyPaymentEvent(ctx context.Context, event PaymentEventInput) (*OrderDetails, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO payment_events (provider, id, type, intent_id, received_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (provider, id) DO NOTHING
+	`, event.Provider, event.EventID, event.Type, event.IntentID, now)
+	if err != nil {
+		return nil, fmt.Errorf("error recording payment event: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("error recording payment event: %w", err)
+	} else if n == 0 {
+		return nil, ErrDuplicatePaymentEvent
+	}
+
+	var orderID string
+	err = tx.QueryRowContext(ctx, `
+		SELECT order_id FROM payments WHERE provider = ? AND intent_id = ?
+	`, event.Provider, event.IntentID).Scan(&orderID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("error getting payment: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE payments
+		SET status = ?, updated_at = ?
+		WHERE provider = ? AND intent_id = ?
+	`, event.Status, now, event.Provider, event.IntentID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating payment: %w", err)
+	}
+
+	if event.OrderStatus != "" {
+		var current sql.NullString
+		err := tx.QueryRowContext(ctx, `SELECT order_status FROM orders WHERE id = ?`, orderID).Scan(&current)
+		if err != nil {
+			return nil, fmt.Errorf("error getting order status: %w", err)
+		}
+		from := OrderStatus(current.String)
+		if from != event.OrderStatus && CanTransition(from, event.OrderStatus) {
+			note := fmt.Sprintf("%s %s", event.Provider, event.Type)
+			if err := setOrderStatus(ctx, tx, orderID, event.OrderStatus, "payments", note); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing payment event: %w", err)
+	}
+
+	return s.GetOrder(ctx, orderID)
+}
@@ -3,23 +3,79 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
+
+	"kaffino/internal/logging"
 )
 
-// Example CreateProduct using sqlc generated code
+// EncodeImages converts a list of image names into the format stored in the
+// products.images column, a JSON array.
+func EncodeImages(images []string) sql.NullString {
+	if len(images) == 0 {
+		return sql.NullString{}
+	}
+	encoded, err := json.Marshal(images)
+	if err != nil {
+		// Marshaling a string slice cannot fail
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(encoded), Valid: true}
+}
+
+// DecodeImages reads the products.images column. Besides JSON arrays it
+// understands the comma separated lists of the seed data and the
+// {"String":...,"Valid":...} objects older versions of UpdateProduct stored.
+func DecodeImages(images sql.NullString) []string {
+	raw := strings.TrimSpace(images.String)
+	if !images.Valid || raw == "" {
+		return []string{}
+	}
+
+	switch raw[0] {
+	case '[':
+		var list []string
+		if err := json.Unmarshal([]byte(raw), &list); err == nil {
+			return list
+		}
+	case '{':
+		var legacy struct{ String string }
+		if err := json.Unmarshal([]byte(raw), &legacy); err == nil {
+			return DecodeImages(sql.NullString{String: legacy.String, Valid: true})
+		}
+	}
+
+	list := []string{}
+	for _, image := range strings.Split(raw, ",") {
+		if image = strings.TrimSpace(image); image != "" {
+			list = append(list, image)
+		}
+	}
+	return list
+}
+
+// CreateProduct inserts a product using sqlc generated code.
 func (s *service) CreateProduct(ctx context.Context, product *Product) error {
+	if product.ID == "" {
+		product.ID = uuid.New().String()
+	}
+	product.CreatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	product.UpdatedAt = product.CreatedAt
+
 	params := CreateProductParams{
 		ID:          product.ID,
 		Code:        product.Code,
 		Images:      product.Images,
 		Title:       product.Title,
 		Description: product.Description,
-		CreatedAt:   sql.NullTime{Time: time.Now(), Valid: true},
-		UpdatedAt:   sql.NullTime{Time: time.Now(), Valid: true},
+		CreatedAt:   product.CreatedAt,
+		UpdatedAt:   product.UpdatedAt,
 	}
 
 	err := s.q.CreateProduct(ctx, params)
@@ -27,11 +83,11 @@ func (s *service) CreateProduct(ctx context.Context, product *Product) error {
 		return fmt.Errorf("error creating product: %w", err)
 	}
 
-	log.Println("Product created successfully")
+	logging.FromContext(ctx).Debug("Product created", "product_id", product.ID)
 	return nil
 }
 
-// Example GetProduct using sqlc generated code
+// GetProduct retrieves a product using sqlc generated code.
 func (s *service) GetProduct(ctx context.Context, id string) (*Product, error) {
 	productRow, err := s.q.GetProduct(ctx, id)
 	if err != nil {
@@ -47,51 +103,207 @@ func (s *service) GetProduct(ctx context.Context, id string) (*Product, error) {
 		Images:      productRow.Images,
 		Title:       productRow.Title,
 		Description: productRow.Description,
+		CreatedAt:   productRow.CreatedAt,
+		UpdatedAt:   productRow.UpdatedAt,
 	}
 
-	log.Println("Product retrieved successfully")
+	logging.FromContext(ctx).Debug("Product retrieved", "product_id", id)
 	return product, nil
 }
 
-// ListProducts retrieves all products from the database.
-func (s *service) ListProducts(ctx context.Context) ([]*Product, error) {
-	query := `
-		SELECT id, code, images, title, description
-		FROM products
-		LIMIT 10
-	`
+// Sort orders accepted by ListProducts.
+const (
+	SortTitle  = "title"
+	SortPrice  = "price"
+	SortNewest = "newest"
+	SortRating = "rating"
+)
+
+const (
+	defaultProductLimit = 20
+	maxProductLimit     = 100
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ProductFilter selects, orders and pages the products returned by ListProducts.
+type ProductFilter struct {
+	Tag      string  // matched like the tag endpoints, in any case
+	MinPrice float64 // zero means no lower bound
+	MaxPrice float64 // zero means no upper bound
+	InStock  bool
+
+	Sort string // one of the Sort constants, SortTitle when empty
+	// Reverse flips the natural direction of the sort: ascending for
+	// title and price, newest and best rated first otherwise.
+	Reverse bool
+
+	Cursor string
+	Limit  int
+}
+
+// ProductPage is one page of products. NextCursor is empty on the last page.
+type ProductPage struct {
+	Products   []*Product
+	NextCursor string
+}
+
+// productCursor is the position after the last product of a page.
+type productCursor struct {
+	Key interface{} `json:"k"`
+	ID  string      `json:"id"`
+}
 
-	rows, err := s.db.QueryContext(ctx, query)
+// ListProducts retrieves a page of products using keyset pagination, so
+// pages stay stable while products are added.
+func (s *service) ListProducts(ctx context.Context, filter ProductFilter) (*ProductPage, error) {
+	var sortKey string
+	descending := false
+	switch filter.Sort {
+	case SortTitle, "":
+		sortKey = "title"
+	case SortPrice:
+		sortKey = "min_price"
+	case SortNewest:
+		sortKey, descending = "created_key", true
+	case SortRating:
+		sortKey, descending = "rating", true
+	default:
+		return nil, fmt.Errorf("unknown sort order %q", filter.Sort)
+	}
+	if filter.Reverse {
+		descending = !descending
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultProductLimit
+	}
+	if limit > maxProductLimit {
+		limit = maxProductLimit
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	if tag := NormalizeTagName(filter.Tag); tag != "" {
+		conditions = append(conditions, `id IN (
+			SELECT pt.product_id
+			FROM product_tags pt
+			JOIN tags t ON t.id = pt.tag_id
+			WHERE t.name = ?
+		)`)
+		args = append(args, tag)
+	}
+	if filter.MinPrice > 0 {
+		conditions = append(conditions, "min_price >= ?")
+		args = append(args, filter.MinPrice)
+	}
+	if filter.MaxPrice > 0 {
+		conditions = append(conditions, "min_price <= ?")
+		args = append(args, filter.MaxPrice)
+	}
+	if filter.InStock {
+		conditions = append(conditions, "stock > 0")
+	}
+	if filter.Cursor != "" {
+		cursor, err := decodeProductCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		op := ">"
+		if descending {
+			op = "<"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", sortKey, op))
+		args = append(args, cursor.Key, cursor.Key, cursor.ID)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, code, images, title, description, created_at, updated_at, %[1]s
+		FROM (
+			SELECT p.id, p.code, p.images, p.title, p.description, p.created_at, p.updated_at,
+				COALESCE((SELECT MIN(price) FROM inventory WHERE product_id = p.id), 0) AS min_price,
+				COALESCE((SELECT SUM(stock) FROM inventory WHERE product_id = p.id), 0) AS stock,
+				COALESCE((SELECT AVG(rating) FROM reviews WHERE product_id = p.id), 0) AS rating,
+				COALESCE(CAST(p.created_at AS TEXT), '') AS created_key
+			FROM products p
+		)
+		%[2]s
+		ORDER BY %[1]s %[3]s, id %[3]s
+		LIMIT ?
+	`, sortKey, where, direction)
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error listing products: %w", err)
 	}
 	defer rows.Close()
 
-	var products []*Product
+	page := &ProductPage{}
+	var lastKey interface{}
 	for rows.Next() {
 		product := &Product{}
+		var key interface{}
 
-		err := rows.Scan(&product.ID, &product.Code, &product.Images, &product.Title, &product.Description)
+		err := rows.Scan(&product.ID, &product.Code, &product.Images, &product.Title, &product.Description,
+			&product.CreatedAt, &product.UpdatedAt, &key)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning product: %w", err)
 		}
 
-		products = append(products, product)
+		if len(page.Products) == limit {
+			// There is at least one more product after this page
+			last := page.Products[len(page.Products)-1]
+			page.NextCursor = encodeProductCursor(productCursor{Key: lastKey, ID: last.ID})
+			break
+		}
+		page.Products = append(page.Products, product)
+		lastKey = key
 	}
 
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("error iterating products: %w", err)
 	}
 
-	return products, nil
+	return page, nil
 }
 
-// UpdateProduct updates a product in the database.
-func (s *service) UpdateProduct(ctx context.Context, product *Product) error {
-	imagesJSON, err := json.Marshal(product.Images)
+func encodeProductCursor(c productCursor) string {
+	if b, ok := c.Key.([]byte); ok {
+		c.Key = string(b)
+	}
+	encoded, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeProductCursor(s string) (productCursor, error) {
+	var c productCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		return fmt.Errorf("error marshaling images: %w", err)
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID == "" {
+		return c, ErrInvalidCursor
 	}
+	return c, nil
+}
+
+// UpdateProduct updates a product in the database.
+// Images are stored exactly as given, in the same format CreateProduct uses.
+func (s *service) UpdateProduct(ctx context.Context, product *Product) error {
 	product.UpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
 	query := `
 		UPDATE products
@@ -99,13 +311,16 @@ func (s *service) UpdateProduct(ctx context.Context, product *Product) error {
 		WHERE id = ?
 	`
 
-	_, err = s.db.ExecContext(ctx, query,
-		product.Code, imagesJSON, product.Title, product.Description, product.UpdatedAt,
+	res, err := s.db.ExecContext(ctx, query,
+		product.Code, product.Images, product.Title, product.Description, product.UpdatedAt,
 		product.ID)
 
 	if err != nil {
 		return fmt.Errorf("error updating product: %w", err)
 	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("product not found: %w", sql.ErrNoRows)
+	}
 
 	return nil
 }
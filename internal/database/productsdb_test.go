@@ -0,0 +1,32 @@
+package database
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeImages(t *testing.T) {
+	want := []string{"whole_bean1.jpg", "whole_bean2.jpg"}
+	tests := map[string]sql.NullString{
+		"json array":     {String: `["whole_bean1.jpg","whole_bean2.jpg"]`, Valid: true},
+		"comma list":     {String: "whole_bean1.jpg, whole_bean2.jpg", Valid: true},
+		"legacy encoded": {String: `{"String":"whole_bean1.jpg, whole_bean2.jpg","Valid":true}`, Valid: true},
+	}
+	for name, images := range tests {
+		if got := DecodeImages(images); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v; got %v", name, want, got)
+		}
+	}
+
+	if got := DecodeImages(sql.NullString{}); len(got) != 0 {
+		t.Errorf("expected no images for NULL; got %v", got)
+	}
+}
+
+func TestEncodeImagesRoundTrip(t *testing.T) {
+	images := []string{"a.jpg", "b, with comma.jpg"}
+	if got := DecodeImages(EncodeImages(images)); !reflect.DeepEqual(got, images) {
+		t.Errorf("expected %v; got %v", images, got)
+	}
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrReviewNotFound is returned when a review does not exist or belongs to another user.
+	ErrReviewNotFound = errors.New("review not found")
+	// ErrReviewExists is returned when a user reviews a product they already reviewed.
+	ErrReviewExists = errors.New("product already reviewed")
+	// ErrInvalidReview is returned for ratings outside MinRating and MaxRating.
+	ErrInvalidReview = errors.New("invalid review")
+)
+
+// Ratings go from one to five stars.
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// Review is a customer's rating and comment on a product.
+type Review struct {
+	ID        string
+	ProductID string
+	UserID    string
+	Rating    int64
+	Comment   sql.NullString
+	CreatedAt sql.NullTime
+	UpdatedAt sql.NullTime
+}
+
+// RatingSummary is the average rating of a product and how many reviews it has.
+type RatingSummary struct {
+	Average float64
+	Count   int64
+}
+
+func validateReview(review *Review) error {
+	if review.Rating < MinRating || review.Rating > MaxRating {
+		return fmt.Errorf("%w: rating must be between %d and %d", ErrInvalidReview, MinRating, MaxRating)
+	}
+	return nil
+}
+
+// CreateReview adds a user's review of a product. A user can review each
+// product once.
+func (s *service) CreateReview(ctx context.Context, review *Review) error {
+	if err := validateReview(review); err != nil {
+		return err
+	}
+	if review.ID == "" {
+		review.ID = uuid.New().String()
+	}
+	now := time.Now()
+	review.CreatedAt = sql.NullTime{Time: now, Valid: true}
+	review.UpdatedAt = sql.NullTime{Time: now, Valid: true}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = ?)`, review.ProductID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking product: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("product not found: %w", sql.ErrNoRows)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO reviews (id, product_id, user_id, rating, comment, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, review.ID, review.ProductID, review.UserID, review.Rating, review.Comment, review.CreatedAt, review.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrReviewExists
+		}
+		return fmt.Errorf("error creating review: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing review: %w", err)
+	}
+	return nil
+}
+
+// UpdateReview changes the rating and comment of a review. Only the user who
+// wrote the review can change it.
+func (s *service) UpdateReview(ctx context.Context, review *Review) error {
+	if err := validateReview(review); err != nil {
+		return err
+	}
+	review.UpdatedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE reviews
+		SET rating = ?, comment = ?, updated_at = ?
+		WHERE id = ? AND product_id = ? AND user_id = ?
+	`, review.Rating, review.Comment, review.UpdatedAt, review.ID, review.ProductID, review.UserID)
+	if err != nil {
+		return fmt.Errorf("error updating review: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrReviewNotFound
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT created_at FROM reviews WHERE id = ?
+	`, review.ID).Scan(&review.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error getting review: %w", err)
+	}
+	return nil
+}
+
+// DeleteReview removes a user's own review of a product.
+func (s *service) DeleteReview(ctx context.Context, userID, productID, reviewID string) error {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM reviews
+		WHERE id = ? AND product_id = ? AND user_id = ?
+	`, reviewID, productID, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting review: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrReviewNotFound
+	}
+	return nil
+}
+
+// ListReviews retrieves the reviews of a product, newest first.
+func (s *service) ListReviews(ctx context.Context, productID string) ([]Review, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, product_id, user_id, rating, comment, created_at, updated_at
+		FROM reviews
+		WHERE product_id = ?
+		ORDER BY created_at DESC, rowid DESC
+	`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing reviews: %w", err)
+	}
+	defer rows.Close()
+
+	var reviews []Review
+	for rows.Next() {
+		var r Review
+		if err := rows.Scan(&r.ID, &r.ProductID, &r.UserID, &r.Rating, &r.Comment, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning review: %w", err)
+		}
+		reviews = append(reviews, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reviews: %w", err)
+	}
+
+	return reviews, nil
+}
+
+// GetRatingSummaries retrieves the rating of the given products, keyed by
+// product ID. Products without reviews are left out.
+func (s *service) GetRatingSummaries(ctx context.Context, productIDs ...string) (map[string]RatingSummary, error) {
+	summaries := make(map[string]RatingSummary)
+	if len(productIDs) == 0 {
+		return summaries, nil
+	}
+
+	args := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT product_id, AVG(rating), COUNT(*)
+		FROM reviews
+		WHERE product_id IN (`+placeholders(len(productIDs))+`)
+		GROUP BY product_id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting ratings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			productID string
+			summary   RatingSummary
+		)
+		if err := rows.Scan(&productID, &summary.Average, &summary.Count); err != nil {
+			return nil, fmt.Errorf("error scanning rating: %w", err)
+		}
+		summaries[productID] = summary
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating ratings: %w", err)
+	}
+
+	return summaries, nil
+}
+
+// HasDeliveredOrder reports whether a user has received an order containing
+// the product.
+func (s *service) HasDeliveredOrder(ctx context.Context, userID, productID string) (bool, error) {
+	var delivered bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM orders o
+			JOIN order_items oi ON oi.order_id = o.id
+			WHERE o.user_id = ? AND oi.product_id = ? AND o.order_status = ?
+		)
+	`, userID, productID, string(OrderDelivered)).Scan(&delivered)
+	if err != nil {
+		return false, fmt.Errorf("error checking delivered orders: %w", err)
+	}
+	return delivered, nil
+}
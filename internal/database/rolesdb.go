@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrUserNotFound is returned when a user does not exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidRole is returned for unknown role names.
+	ErrInvalidRole = errors.New("invalid role")
+)
+
+// Role is what a user is allowed to do. Each role can do everything the
+// roles below it can.
+type Role string
+
+const (
+	// RoleCustomer browses, buys and reviews. Every new user is a customer.
+	RoleCustomer Role = "customer"
+	// RoleStaff also manages the catalog and moves orders through fulfillment.
+	RoleStaff Role = "staff"
+	// RoleAdmin also manages the roles of other users.
+	RoleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleCustomer: 1,
+	RoleStaff:    2,
+	RoleAdmin:    3,
+}
+
+// ParseRole parses a role name such as "staff".
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("%w: %q", ErrInvalidRole, s)
+	}
+	return role, nil
+}
+
+// Includes reports whether the role grants everything other grants.
+func (r Role) Includes(other Role) bool {
+	rank, ok := roleRank[r]
+	return ok && rank >= roleRank[other]
+}
+
+// GetUserRole retrieves the role of a user.
+func (s *service) GetUserRole(ctx context.Context, userID string) (Role, error) {
+	var role string
+	err := s.db.QueryRowContext(ctx, `SELECT role FROM users WHERE id = ?`, userID).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrUserNotFound
+		}
+		return "", fmt.Errorf("error getting user role: %w", err)
+	}
+	return Role(role), nil
+}
+
+// SetUserRole changes the role of a user.
+func (s *service) SetUserRole(ctx context.Context, userID string, role Role) error {
+	if _, ok := roleRank[role]; !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE users
+		SET role = ?, updated_at = ?
+		WHERE id = ?
+	`, string(role), time.Now(), userID)
+	if err != nil {
+		return fmt.Errorf("error setting user role: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package database
+
+import "testing"
+
+func TestRoleIncludes(t *testing.T) {
+	tests := []struct {
+		role, other Role
+		want        bool
+	}{
+		{RoleAdmin, RoleStaff, true},
+		{RoleAdmin, RoleCustomer, true},
+		{RoleStaff, RoleStaff, true},
+		{RoleStaff, RoleCustomer, true},
+		{RoleStaff, RoleAdmin, false},
+		{RoleCustomer, RoleStaff, false},
+		{Role("owner"), RoleCustomer, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.Includes(tt.other); got != tt.want {
+			t.Errorf("%s.Includes(%s) = %v; want %v", tt.role, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	if _, err := ParseRole("staff"); err != nil {
+		t.Errorf("expected staff to be valid; got %v", err)
+	}
+	if _, err := ParseRole("owner"); err == nil {
+		t.Error("expected unknown role to be rejected")
+	}
+}
@@ -1,92 +0,0 @@
-CREATE TABLE IF NOT EXISTS users (
-    id VARCHAR(36) PRIMARY KEY,
-    email VARCHAR(255) UNIQUE NOT NULL,
-    subscriber BOOLEAN DEFAULT FALSE,
-    username VARCHAR(255),
-    created_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
-    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP)
-);
-
-CREATE TABLE IF NOT EXISTS products (
-    id VARCHAR(36) PRIMARY KEY,
-    code VARCHAR(255) UNIQUE NOT NULL,
-    images TEXT,
-    title VARCHAR(255) NOT NULL,
-    description TEXT,
-    created_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
-    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP)
-);
-
-CREATE TABLE IF NOT EXISTS tags (
-    id VARCHAR(36) PRIMARY KEY,
-    name VARCHAR(255) UNIQUE NOT NULL,
-    created_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
-    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP)
-);
-
-CREATE TABLE IF NOT EXISTS product_tags (
-    product_id VARCHAR(36) NOT NULL,
-    tag_id VARCHAR(36) NOT NULL,
-    PRIMARY KEY (product_id, tag_id),
-    FOREIGN KEY (product_id) REFERENCES products(id),
-    FOREIGN KEY (tag_id) REFERENCES tags(id)
-);
-
-CREATE INDEX idx_product_tags_product_id ON product_tags (product_id);
-CREATE INDEX idx_product_tags_tag_id ON product_tags (tag_id);
-
-CREATE TABLE IF NOT EXISTS inventory (
-    id VARCHAR(36) PRIMARY KEY,
-    product_id VARCHAR(36) NOT NULL,
-    stock INTEGER NOT NULL DEFAULT 0,
-    sizes TEXT,
-    price DECIMAL(10,2) NOT NULL DEFAULT 0.0,
-    created_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
-    updated_at DATETIME DEFAULT (CURRENT_TIMESTAMP),
-    FOREIGN KEY (product_id) REFERENCES products(id)
-);
-
-CREATE INDEX idx_inventory_product_id ON inventory (product_id);
-
-CREATE TABLE IF NOT EXISTS orders (
-    id VARCHAR(36) PRIMARY KEY,
-    user_id VARCHAR(36) NOT NULL,
-    order_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    total_amount DECIMAL(10, 2) NOT NULL,
-    shipping_address TEXT,
-    billing_address TEXT,
-    payment_method VARCHAR(255),
-    order_status TEXT DEFAULT 'Pending',
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id)
-);
-
-CREATE INDEX idx_orders_user_id ON orders (user_id);
-
-CREATE TABLE IF NOT EXISTS order_items (
-    id VARCHAR(36) PRIMARY KEY,
-    order_id VARCHAR(36) NOT NULL,
-    product_id VARCHAR(36) NOT NULL,
-    quantity INTEGER NOT NULL,
-    price DECIMAL(10, 2) NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (order_id) REFERENCES orders(id),
-    FOREIGN KEY (product_id) REFERENCES products(id)
-);
-
-CREATE TABLE IF NOT EXISTS reviews (
-    id VARCHAR(36) PRIMARY KEY,
-    product_id VARCHAR(36) NOT NULL,
-    user_id VARCHAR(36) NOT NULL,
-    rating INTEGER NOT NULL,
-    comment TEXT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (product_id) REFERENCES products(id),
-    FOREIGN KEY (user_id) REFERENCES users(id)
-);
-
-CREATE INDEX idx_reviews_product_id ON reviews (product_id);
-CREATE INDEX idx_reviews_user_id ON reviews (user_id);
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// ProductSearchResult is a product matching a search, with an HTML snippet
+// of the matching text where the matched terms are wrapped in <mark> tags.
+type ProductSearchResult struct {
+	Product
+	Snippet string
+	Rank    float64
+}
+
+// SearchProducts runs a full-text search over product titles, descriptions,
+// codes and tag names. Results are ordered by relevance, with title matches
+// weighing the most.
+func (s *service) SearchProducts(ctx context.Context, query string, limit int) ([]ProductSearchResult, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return nil, nil
+	}
+	if limit <= 0 {
+		limit = defaultProductLimit
+	}
+	if limit > maxProductLimit {
+		limit = maxProductLimit
+	}
+
+	// bm25 weights follow the column order: product_id, title, description, code, tags
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id, p.code, p.images, p.title, p.description, p.created_at, p.updated_at,
+			snippet(product_search, -1, char(2), char(3), '…', 12),
+			bm25(product_search, 0.0, 10.0, 2.0, 5.0, 3.0) AS rank
+		FROM product_search
+		JOIN products p ON p.id = product_search.product_id
+		WHERE product_search MATCH ?
+		ORDER BY rank
+		LIMIT ?
+	`, match, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error searching products: %w", err)
+	}
+	defer rows.Close()
+
+	var results []ProductSearchResult
+	for rows.Next() {
+		var r ProductSearchResult
+		err := rows.Scan(&r.ID, &r.Code, &r.Images, &r.Title, &r.Description, &r.CreatedAt, &r.UpdatedAt,
+			&r.Snippet, &r.Rank)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning search result: %w", err)
+		}
+		r.Snippet = highlight(r.Snippet)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search results: %w", err)
+	}
+
+	return results, nil
+}
+
+// highlight escapes a snippet, whose matched terms FTS5 marked with the
+// STX and ETX control characters, and wraps those terms in <mark> tags. The
+// snippet is product text, so it must not reach the client as markup.
+func highlight(snippet string) string {
+	return strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>").Replace(html.EscapeString(snippet))
+}
+
+// ftsQuery turns free text typed by a customer into an FTS5 query. Every
+// word becomes a quoted prefix term, so "chanch gei" finds "Chanchamayo
+// Geisha" and stray quotes or operators cannot break the query syntax.
+func ftsQuery(text string) string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
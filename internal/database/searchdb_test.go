@@ -0,0 +1,59 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := map[string]string{
+		"geisha":            `"geisha"*`,
+		"Chanchamayo  gei":  `"Chanchamayo"* "gei"*`,
+		`"single" origin -`: `"single"* "origin"*`,
+		"café OR":           `"café"* "OR"*`,
+		"  ":                "",
+	}
+	for in, want := range tests {
+		if got := ftsQuery(in); got != want {
+			t.Errorf("ftsQuery(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestSearchProducts(t *testing.T) {
+	s := migratedDB(t)
+	ctx := context.Background()
+
+	products := []*Product{
+		{ID: "p1", Code: "CH-01", Title: "Chanchamayo Geisha", Description: sql.NullString{String: "Floral and bright", Valid: true}},
+		{ID: "p2", Code: "CU-01", Title: "Cusco Bourbon", Description: sql.NullString{String: "Chocolate notes", Valid: true}},
+		{ID: "p3", Code: "PU-01", Title: "Puno", Description: sql.NullString{String: `<img src=x onerror="alert(1)"> Tropical fruit`, Valid: true}},
+	}
+	for _, p := range products {
+		if err := s.CreateProduct(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.SearchProducts(ctx, "chanch gei", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].ID != "p1" {
+		t.Fatalf("expected only p1 to match; got %+v", results)
+	}
+	if want := "<mark>Chanchamayo</mark> <mark>Geisha</mark>"; results[0].Snippet != want {
+		t.Errorf("expected snippet %q; got %q", want, results[0].Snippet)
+	}
+
+	// Product text is escaped before the matched terms are highlighted
+	results, err = s.SearchProducts(ctx, "tropical", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <mark>Tropical</mark> fruit"
+	if len(results) != 1 || results[0].Snippet != want {
+		t.Errorf("expected snippet %q; got %+v", want, results)
+	}
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSessionNotFound is returned when a session does not exist, has expired
+// or belongs to another user.
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a login session.
+type Session struct {
+	ID         string
+	UserID     sql.NullString
+	Data       []byte
+	UserAgent  string
+	IpAddress  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// GetSession retrieves an unexpired session.
+func (s *service) GetSession(ctx context.Context, id string, now time.Time) (*Session, error) {
+	var session Session
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, user_id, data, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE id = ? AND expires_at > ?
+	`, id, now.UTC()).Scan(&session.ID, &session.UserID, &session.Data, &session.UserAgent, &session.IpAddress,
+		&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("error getting session: %w", err)
+	}
+	return &session, nil
+}
+
+// SaveSession creates or updates a session.
+func (s *service) SaveSession(ctx context.Context, session *Session) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO sessions (id, user_id, data, user_agent, ip_address, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE
+		SET user_id = excluded.user_id, data = excluded.data, user_agent = excluded.user_agent,
+			ip_address = excluded.ip_address, last_seen_at = excluded.last_seen_at, expires_at = excluded.expires_at
+	`, session.ID, session.UserID, session.Data, session.UserAgent, session.IpAddress,
+		session.CreatedAt.UTC(), session.LastSeenAt.UTC(), session.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("error saving session: %w", err)
+	}
+	return nil
+}
+
+// TouchSession records that a session was used. To save writes it only
+// updates sessions not seen since the given time.
+func (s *service) TouchSession(ctx context.Context, id string, now, notSeenSince time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE sessions
+		SET last_seen_at = ?
+		WHERE id = ? AND last_seen_at < ?
+	`, now.UTC(), id, notSeenSince.UTC())
+	if err != nil {
+		return fmt.Errorf("error touching session: %w", err)
+	}
+	return nil
+}
+
+// DeleteSession deletes a session.
+func (s *service) DeleteSession(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+	return nil
+}
+
+// ListUserSessions retrieves the unexpired sessions of a user, most
+// recently used first.
+func (s *service) ListUserSessions(ctx context.Context, userID string, now time.Time) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, user_agent, ip_address, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`, userID, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IpAddress,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteUserSession revokes one session of a user.
+func (s *service) DeleteUserSession(ctx context.Context, userID, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteUserSessions revokes every session of a user. It returns how many
+// sessions were revoked.
+func (s *service) DeleteUserSessions(ctx context.Context, userID string) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error deleting sessions: %w", err)
+	}
+	return n, nil
+}
+
+// DeleteExpiredSessions removes the sessions that expired before the given
+// time. It returns how many were removed.
+func (s *service) DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired sessions: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired sessions: %w", err)
+	}
+	return n, nil
+}
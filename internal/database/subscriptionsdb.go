@@ -0,0 +1,434 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Subscription is a recurring order of a product variant.
+type Subscription struct {
+	ID          string
+	UserID      string
+	InventoryID string
+	Quantity    int64
+	Cadence     string
+	Status      string
+	NextRunAt   time.Time
+	// AnchorDay is the day of the month monthly runs fall on.
+	AnchorDay       int
+	ReminderSent    bool
+	ShippingAddress sql.NullString
+	BillingAddress  sql.NullString
+	PaymentMethod   sql.NullString
+	LastOrderID     sql.NullString
+	CreatedAt       sql.NullTime
+	UpdatedAt       sql.NullTime
+}
+
+// Cadence is how often a subscription delivers.
+type Cadence string
+
+const (
+	CadenceWeekly   Cadence = "weekly"
+	CadenceBiweekly Cadence = "biweekly"
+	CadenceMonthly  Cadence = "monthly"
+)
+
+// ParseCadence validates a cadence name.
+func ParseCadence(s string) (Cadence, error) {
+	switch c := Cadence(s); c {
+	case CadenceWeekly, CadenceBiweekly, CadenceMonthly:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown cadence %q", s)
+}
+
+// Next returns the run that follows one at t. Monthly runs fall on
+// anchorDay, or on the last day of months too short for it, so a
+// subscription started on the 31st runs at the end of February and is back
+// on the 31st in March. An anchorDay of zero is the day of t.
+func (c Cadence) Next(t time.Time, anchorDay int) time.Time {
+	switch c {
+	case CadenceWeekly:
+		return t.AddDate(0, 0, 7)
+	case CadenceBiweekly:
+		return t.AddDate(0, 0, 14)
+	default:
+		if anchorDay <= 0 {
+			anchorDay = t.Day()
+		}
+		// Day 0 of the month after next is the last day of next month
+		last := time.Date(t.Year(), t.Month()+2, 0, 0, 0, 0, 0, t.Location()).Day()
+		return time.Date(t.Year(), t.Month()+1, min(anchorDay, last),
+			t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	}
+}
+
+// after returns the first run of the schedule through t that comes after
+// now, so a subscription that fell behind does not place catch-up orders.
+func (c Cadence) after(t, now time.Time, anchorDay int) time.Time {
+	for !t.After(now) {
+		t = c.Next(t, anchorDay)
+	}
+	return t
+}
+
+// SubscriptionStatus is the state of a subscription. Cancelled is final.
+type SubscriptionStatus string
+
+const (
+	SubscriptionActive    SubscriptionStatus = "active"
+	SubscriptionPaused    SubscriptionStatus = "paused"
+	SubscriptionCancelled SubscriptionStatus = "cancelled"
+)
+
+var (
+	// ErrSubscriptionNotFound is returned when a subscription does not exist.
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	// ErrInvalidSubscription is returned when a subscription request is
+	// incomplete.
+	ErrInvalidSubscription = errors.New("invalid subscription")
+	// ErrSubscriptionState is returned when a subscription cannot be paused,
+	// resumed, skipped or cancelled in its current status.
+	ErrSubscriptionState = errors.New("invalid subscription status change")
+	// ErrSubscriptionNotDue is returned when running a subscription that is
+	// not active or not due yet.
+	ErrSubscriptionNotDue = errors.New("subscription is not due")
+)
+
+// SubscriptionInput holds everything needed to start a subscription.
+type SubscriptionInput struct {
+	VariantID string
+	Quantity  int64
+	Cadence   Cadence
+	// NextRunAt is when the first order is placed, now when zero.
+	NextRunAt       time.Time
+	ShippingAddress string
+	BillingAddress  string
+	PaymentMethod   string
+}
+
+// SubscriptionDetails is a subscription together with what it delivers and
+// to whom.
+type SubscriptionDetails struct {
+	Subscription
+	ProductID    string
+	ProductTitle string
+	Size         string
+	Price        float64
+	Email        string
+}
+
+const subscriptionQuery = `
+	SELECT s.id, s.user_id, s.inventory_id, s.quantity, s.cadence, s.status, s.next_run_at, s.anchor_day, s.reminder_sent,
+		s.shipping_address, s.billing_address, s.payment_method, s.last_order_id, s.created_at, s.updated_at,
+		COALESCE(i.product_id, ''), COALESCE(p.title, ''), COALESCE(i.sizes, ''), COALESCE(i.price, 0), u.email
+	FROM subscriptions s
+	JOIN users u ON u.id = s.user_id
+	LEFT JOIN inventory i ON i.id = s.inventory_id
+	LEFT JOIN products p ON p.id = i.product_id
+`
+
+func scanSubscription(row interface{ Scan(...interface{}) error }) (*SubscriptionDetails, error) {
+	sub := &SubscriptionDetails{}
+	err := row.Scan(&sub.ID, &sub.UserID, &sub.InventoryID, &sub.Quantity, &sub.Cadence, &sub.Status, &sub.NextRunAt,
+		&sub.AnchorDay, &sub.ReminderSent, &sub.ShippingAddress, &sub.BillingAddress, &sub.PaymentMethod, &sub.LastOrderID,
+		&sub.CreatedAt, &sub.UpdatedAt, &sub.ProductID, &sub.ProductTitle, &sub.Size, &sub.Price, &sub.Email)
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *service) listSubscriptions(ctx context.Context, where string, args ...interface{}) ([]SubscriptionDetails, error) {
+	rows, err := s.db.QueryContext(ctx, subscriptionQuery+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []SubscriptionDetails
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning subscription: %w", err)
+		}
+		subs = append(subs, *sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating subscriptions: %w", err)
+	}
+	return subs, nil
+}
+
+// CreateSubscription starts a subscription to a product variant and marks
+// the user as a subscriber.
+func (s *service) CreateSubscription(ctx context.Context, userID string, input SubscriptionInput) (*SubscriptionDetails, error) {
+	if input.VariantID == "" || input.Quantity <= 0 {
+		return nil, fmt.Errorf("%w: a subscription needs a variant and a positive quantity", ErrInvalidSubscription)
+	}
+	if _, err := ParseCadence(string(input.Cadence)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSubscription, err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM inventory WHERE id = ?`, input.VariantID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("error getting variant: %w", err)
+	}
+	if exists == 0 {
+		return nil, ErrVariantNotFound
+	}
+
+	now := time.Now().UTC()
+	nextRun := input.NextRunAt
+	if nextRun.IsZero() {
+		nextRun = now
+	}
+	id := uuid.New().String()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO subscriptions (id, user_id, inventory_id, quantity, cadence, status, next_run_at, anchor_day,
+			shipping_address, billing_address, payment_method, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, userID, input.VariantID, input.Quantity, string(input.Cadence), string(SubscriptionActive), nextRun.UTC(),
+		nextRun.UTC().Day(),
+		nullString(input.ShippingAddress), nullString(input.BillingAddress), nullString(input.PaymentMethod), now, now)
+	if err != nil {
+		return nil, fmt.Errorf("error creating subscription: %w", err)
+	}
+	if err := refreshSubscriber(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing subscription: %w", err)
+	}
+	return s.GetSubscription(ctx, id)
+}
+
+// GetSubscription retrieves a subscription by ID.
+func (s *service) GetSubscription(ctx context.Context, id string) (*SubscriptionDetails, error) {
+	sub, err := scanSubscription(s.db.QueryRowContext(ctx, subscriptionQuery+`WHERE s.id = ?`, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, fmt.Errorf("error getting subscription: %w", err)
+	}
+	return sub, nil
+}
+
+// ListSubscriptions retrieves the subscriptions of a user, newest first.
+func (s *service) ListSubscriptions(ctx context.Context, userID string) ([]SubscriptionDetails, error) {
+	return s.listSubscriptions(ctx, `WHERE s.user_id = ? ORDER BY s.created_at DESC`, userID)
+}
+
+// PauseSubscription stops an active subscription from placing orders until
+// it is resumed.
+func (s *service) PauseSubscription(ctx context.Context, userID, id string) (*SubscriptionDetails, error) {
+	return s.changeSubscription(ctx, userID, id, func(sub *Subscription) error {
+		if SubscriptionStatus(sub.Status) != SubscriptionActive {
+			return fmt.Errorf("%w: cannot pause a %s subscription", ErrSubscriptionState, sub.Status)
+		}
+		sub.Status = string(SubscriptionPaused)
+		return nil
+	})
+}
+
+// ResumeSubscription reactivates a paused subscription. Runs missed while
+// it was paused are not made up for.
+func (s *service) ResumeSubscription(ctx context.Context, userID, id string, now time.Time) (*SubscriptionDetails, error) {
+	return s.changeSubscription(ctx, userID, id, func(sub *Subscription) error {
+		if SubscriptionStatus(sub.Status) != SubscriptionPaused {
+			return fmt.Errorf("%w: cannot resume a %s subscription", ErrSubscriptionState, sub.Status)
+		}
+		sub.Status = string(SubscriptionActive)
+		sub.NextRunAt = Cadence(sub.Cadence).after(sub.NextRunAt, now, sub.AnchorDay)
+		return nil
+	})
+}
+
+// SkipSubscription moves the next run of a subscription one period later.
+func (s *service) SkipSubscription(ctx context.Context, userID, id string, now time.Time) (*SubscriptionDetails, error) {
+	return s.changeSubscription(ctx, userID, id, func(sub *Subscription) error {
+		if SubscriptionStatus(sub.Status) == SubscriptionCancelled {
+			return fmt.Errorf("%w: cannot skip a cancelled subscription", ErrSubscriptionState)
+		}
+		cadence := Cadence(sub.Cadence)
+		sub.NextRunAt = cadence.after(cadence.Next(sub.NextRunAt, sub.AnchorDay), now, sub.AnchorDay)
+		return nil
+	})
+}
+
+// CancelSubscription ends a subscription for good.
+func (s *service) CancelSubscription(ctx context.Context, userID, id string) (*SubscriptionDetails, error) {
+	return s.changeSubscription(ctx, userID, id, func(sub *Subscription) error {
+		if SubscriptionStatus(sub.Status) == SubscriptionCancelled {
+			return fmt.Errorf("%w: subscription is already cancelled", ErrSubscriptionState)
+		}
+		sub.Status = string(SubscriptionCancelled)
+		return nil
+	})
+}
+
+// changeSubscription applies a status or schedule change to a subscription
+// of a user inside a transaction. A new next run gets a new reminder.
+func (s *service) changeSubscription(ctx context.Context, userID, id string, change func(*Subscription) error) (*SubscriptionDetails, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sub Subscription
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, cadence, status, next_run_at, anchor_day, reminder_sent
+		FROM subscriptions
+		WHERE id = ? AND user_id = ?
+	`, id, userID).Scan(&sub.ID, &sub.UserID, &sub.Cadence, &sub.Status, &sub.NextRunAt, &sub.AnchorDay, &sub.ReminderSent)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSubscriptionNotFound
+		}
+		return nil, fmt.Errorf("error getting subscription: %w", err)
+	}
+
+	nextRun := sub.NextRunAt
+	if err := change(&sub); err != nil {
+		return nil, err
+	}
+	if !sub.NextRunAt.Equal(nextRun) {
+		sub.ReminderSent = false
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET status = ?, next_run_at = ?, reminder_sent = ?, updated_at = ?
+		WHERE id = ?
+	`, sub.Status, sub.NextRunAt.UTC(), sub.ReminderSent, time.Now().UTC(), sub.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error updating subscription: %w", err)
+	}
+	if err := refreshSubscriber(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing subscription: %w", err)
+	}
+	return s.GetSubscription(ctx, id)
+}
+
+// refreshSubscriber sets the subscriber flag of a user to whether they have
+// a subscription that is not cancelled.
+func refreshSubscriber(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET subscriber = EXISTS (SELECT 1 FROM subscriptions WHERE user_id = ? AND status != ?)
+		WHERE id = ?
+	`, userID, string(SubscriptionCancelled), userID)
+	if err != nil {
+		return fmt.Errorf("error updating subscriber flag: %w", err)
+	}
+	return nil
+}
+
+// ListSubscriptionsToRemind lists the active subscriptions due after from
+// and up to until whose customer has not been reminded of the run yet.
+func (s *service) ListSubscriptionsToRemind(ctx context.Context, from, until time.Time) ([]SubscriptionDetails, error) {
+	return s.listSubscriptions(ctx, `
+		WHERE s.status = ? AND NOT s.reminder_sent AND s.next_run_at > ? AND s.next_run_at <= ?
+		ORDER BY s.next_run_at
+	`, string(SubscriptionActive), from.UTC(), until.UTC())
+}
+
+// MarkSubscriptionReminded records that the customer was reminded of the
+// next run of a subscription.
+func (s *service) MarkSubscriptionReminded(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE subscriptions SET reminder_sent = TRUE WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("error marking subscription reminded: %w", err)
+	}
+	return nil
+}
+
+// ListDueSubscriptions lists the active subscriptions whose next run is due
+// at now.
+func (s *service) ListDueSubscriptions(ctx context.Context, now time.Time) ([]SubscriptionDetails, error) {
+	return s.listSubscriptions(ctx, `
+		WHERE s.status = ? AND s.next_run_at <= ?
+		ORDER BY s.next_run_at
+	`, string(SubscriptionActive), now.UTC())
+}
+
+// RunSubscription places the order of a due subscription with the given
+// charges, and schedules its next run, in one transaction so a
+// run is never placed twice. It returns
+// ErrSubscriptionNotDue when the subscription is no longer due, and the
+// errors of CreateOrder, such as ErrInsufficientStock, when the order cannot
+// be placed.
+func (s *service) RunSubscription(ctx context.Context, id string, charges Charges, now time.Time) (*OrderDetails, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		sub       Subscription
+		productID sql.NullString
+	)
+	err = tx.QueryRowContext(ctx, `
+		SELECT s.id, s.user_id, s.inventory_id, s.quantity, s.cadence, s.next_run_at, s.anchor_day, s.shipping_address,
+			s.billing_address, s.payment_method, i.product_id
+		FROM subscriptions s
+		LEFT JOIN inventory i ON i.id = s.inventory_id
+		WHERE s.id = ? AND s.status = ? AND s.next_run_at <= ?
+	`, id, string(SubscriptionActive), now.UTC()).Scan(&sub.ID, &sub.UserID, &sub.InventoryID, &sub.Quantity, &sub.Cadence,
+		&sub.NextRunAt, &sub.AnchorDay, &sub.ShippingAddress, &sub.BillingAddress, &sub.PaymentMethod, &productID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSubscriptionNotDue
+		}
+		return nil, fmt.Errorf("error getting subscription: %w", err)
+	}
+	if !productID.Valid {
+		return nil, ErrVariantNotFound
+	}
+
+	order, err := createOrder(ctx, tx, sub.UserID, OrderInput{
+		ShippingAddress: sub.ShippingAddress.String,
+		BillingAddress:  sub.BillingAddress.String,
+		PaymentMethod:   sub.PaymentMethod.String,
+		Items:           []OrderItemInput{{ProductID: productID.String, VariantID: sub.InventoryID, Quantity: sub.Quantity}},
+		Charges:         charges,
+	}, now)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET next_run_at = ?, reminder_sent = FALSE, last_order_id = ?, updated_at = ?
+		WHERE id = ?
+	`, Cadence(sub.Cadence).after(sub.NextRunAt, now, sub.AnchorDay).UTC(), order.ID, now.UTC(), sub.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error scheduling subscription: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing subscription run: %w", err)
+	}
+	return order, nil
+}
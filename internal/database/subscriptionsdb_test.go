@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCadenceNext(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		cadence Cadence
+		want    time.Time
+	}{
+		{CadenceWeekly, time.Date(2024, time.February, 7, 9, 0, 0, 0, time.UTC)},
+		{CadenceBiweekly, time.Date(2024, time.February, 14, 9, 0, 0, 0, time.UTC)},
+		{CadenceMonthly, time.Date(2024, time.February, 29, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := tt.cadence.Next(start, 31); !got.Equal(tt.want) {
+			t.Errorf("%s.Next(%v) = %v; want %v", tt.cadence, start, got, tt.want)
+		}
+	}
+}
+
+func TestCadenceNextMonthlyKeepsAnchorDay(t *testing.T) {
+	run := time.Date(2023, time.December, 31, 9, 0, 0, 0, time.UTC)
+	want := []time.Time{
+		time.Date(2024, time.January, 31, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.February, 29, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.March, 31, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.April, 30, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.May, 31, 9, 0, 0, 0, time.UTC),
+	}
+	for _, w := range want {
+		if run = CadenceMonthly.Next(run, 31); !run.Equal(w) {
+			t.Fatalf("expected the next monthly run on %v; got %v", w, run)
+		}
+	}
+
+	// Without an anchor the day of the run is kept
+	if got := CadenceMonthly.Next(time.Date(2024, time.March, 15, 9, 0, 0, 0, time.UTC), 0); got.Day() != 15 || got.Month() != time.April {
+		t.Errorf("expected April 15; got %v", got)
+	}
+}
+
+func TestCadenceAfter(t *testing.T) {
+	start := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+	now := start.AddDate(0, 0, 20)
+	want := start.AddDate(0, 0, 21)
+	if got := CadenceWeekly.after(start, now, 0); !got.Equal(want) {
+		t.Errorf("after skipped to %v; want %v", got, want)
+	}
+	if got := CadenceWeekly.after(now.Add(time.Hour), now, 0); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected a future run to be kept; got %v", got)
+	}
+}
+
+func TestParseCadence(t *testing.T) {
+	if _, err := ParseCadence("biweekly"); err != nil {
+		t.Errorf("expected biweekly to be valid; got %v", err)
+	}
+	if _, err := ParseCadence("daily"); err == nil {
+		t.Error("expected unknown cadence to be rejected")
+	}
+}
+
+func TestAnchorDayMigration(t *testing.T) {
+	db, err := sql.Open("sqlite", dsn(filepath.Join(t.TempDir(), "kaffino.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	s := &service{db: db, migrations: migrations[:6]}
+	if _, err := s.MigrateUp(ctx); err != nil {
+		t.Fatalf("error applying migrations. Err: %v", err)
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO subscriptions (id, user_id, inventory_id, quantity, cadence, next_run_at)
+		VALUES ('s1', 'u1', 'v1', 1, 'monthly', ?)
+	`, time.Date(2024, time.January, 31, 14, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.migrations = migrations
+	if _, err := s.MigrateUp(ctx); err != nil {
+		t.Fatalf("error applying migrations. Err: %v", err)
+	}
+	var day int
+	if err := db.QueryRowContext(ctx, `SELECT anchor_day FROM subscriptions WHERE id = 's1'`).Scan(&day); err != nil {
+		t.Fatal(err)
+	}
+	if day != 31 {
+		t.Errorf("expected the anchor day of the next run; got %d", day)
+	}
+}
@@ -0,0 +1,250 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	// ErrTagNotFound is returned when a tag does not exist.
+	ErrTagNotFound = errors.New("tag not found")
+	// ErrTagExists is returned when a tag name is already taken.
+	ErrTagExists = errors.New("tag already exists")
+	// ErrInvalidTag is returned for empty tag names.
+	ErrInvalidTag = errors.New("invalid tag name")
+)
+
+// NormalizeTagName trims and lowercases a tag name so "Single Origin" and
+// "single origin " are the same tag.
+func NormalizeTagName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// CreateTag creates a new tag.
+func (s *service) CreateTag(ctx context.Context, name string) (*Tag, error) {
+	return insertTag(ctx, s.q, name)
+}
+
+func insertTag(ctx context.Context, q *Queries, name string) (*Tag, error) {
+	name = NormalizeTagName(name)
+	if name == "" {
+		return nil, ErrInvalidTag
+	}
+
+	now := sql.NullTime{Time: time.Now(), Valid: true}
+	tag := &Tag{ID: uuid.New().String(), Name: name, CreatedAt: now, UpdatedAt: now}
+	if err := q.CreateTag(ctx, CreateTagParams{ID: tag.ID, Name: tag.Name}); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrTagExists
+		}
+		return nil, fmt.Errorf("error creating tag: %w", err)
+	}
+	return tag, nil
+}
+
+// GetTagByName retrieves a tag by its name.
+func (s *service) GetTagByName(ctx context.Context, name string) (*Tag, error) {
+	tag, err := s.q.GetTagByName(ctx, NormalizeTagName(name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTagNotFound
+		}
+		return nil, fmt.Errorf("error getting tag: %w", err)
+	}
+	return &tag, nil
+}
+
+// ListTags retrieves all tags ordered by name.
+func (s *service) ListTags(ctx context.Context) ([]Tag, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, name, created_at, updated_at FROM tags ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []Tag
+	for rows.Next() {
+		var t Tag
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning tag: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tags: %w", err)
+	}
+	return tags, nil
+}
+
+// RenameTag changes the name of a tag.
+func (s *service) RenameTag(ctx context.Context, id, name string) (*Tag, error) {
+	name = NormalizeTagName(name)
+	if name == "" {
+		return nil, ErrInvalidTag
+	}
+
+	res, err := s.db.ExecContext(ctx, `UPDATE tags SET name = ?, updated_at = ? WHERE id = ?`, name, time.Now(), id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrTagExists
+		}
+		return nil, fmt.Errorf("error renaming tag: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("error renaming tag: %w", err)
+	}
+	if n == 0 {
+		return nil, ErrTagNotFound
+	}
+
+	tag, err := s.q.GetTag(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting tag: %w", err)
+	}
+	return &tag, nil
+}
+
+// DeleteTag deletes a tag and detaches it from every product.
+func (s *service) DeleteTag(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM product_tags WHERE tag_id = ?`, id); err != nil {
+		return fmt.Errorf("error detaching tag: %w", err)
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting tag: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting tag: %w", err)
+	}
+	if n == 0 {
+		return ErrTagNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing tag deletion: %w", err)
+	}
+	return nil
+}
+
+// AttachTag tags a product, creating the tag if it does not exist yet.
+// Attaching a tag the product already has is not an error.
+func (s *service) AttachTag(ctx context.Context, productID, name string) (*Tag, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	q := s.q.WithTx(tx)
+	if _, err := q.GetProduct(ctx, productID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("product not found: %w", err)
+		}
+		return nil, fmt.Errorf("error getting product: %w", err)
+	}
+
+	tag, err := q.GetTagByName(ctx, NormalizeTagName(name))
+	if err == sql.ErrNoRows {
+		created, err := insertTag(ctx, q, name)
+		if err != nil {
+			return nil, err
+		}
+		tag = *created
+	} else if err != nil {
+		return nil, fmt.Errorf("error getting tag: %w", err)
+	}
+
+	err = q.CreateProductTag(ctx, CreateProductTagParams{ProductID: productID, TagID: tag.ID})
+	if err != nil && !isUniqueViolation(err) {
+		return nil, fmt.Errorf("error tagging product: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing product tag: %w", err)
+	}
+	return &tag, nil
+}
+
+// DetachTag removes a tag from a product.
+func (s *service) DetachTag(ctx context.Context, productID, name string) error {
+	tag, err := s.GetTagByName(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM product_tags WHERE product_id = ? AND tag_id = ?`, productID, tag.ID)
+	if err != nil {
+		return fmt.Errorf("error removing product tag: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error removing product tag: %w", err)
+	}
+	if n == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+// ListProductTags retrieves the tag names of the given products, keyed by product ID.
+func (s *service) ListProductTags(ctx context.Context, productIDs ...string) (map[string][]string, error) {
+	tags := make(map[string][]string)
+	if len(productIDs) == 0 {
+		return tags, nil
+	}
+
+	args := make([]interface{}, len(productIDs))
+	for i, id := range productIDs {
+		args[i] = id
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT pt.product_id, t.name
+		FROM product_tags pt
+		JOIN tags t ON t.id = pt.tag_id
+		WHERE pt.product_id IN (`+placeholders(len(productIDs))+`)
+		ORDER BY t.name
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing product tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID, name string
+		if err := rows.Scan(&productID, &name); err != nil {
+			return nil, fmt.Errorf("error scanning product tag: %w", err)
+		}
+		tags[productID] = append(tags[productID], name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating product tags: %w", err)
+	}
+
+	return tags, nil
+}
+
+// isUniqueViolation reports whether err comes from a UNIQUE or PRIMARY KEY constraint.
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+			sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return false
+}
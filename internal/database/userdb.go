@@ -1,34 +1,46 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"fmt"
 
 	"github.com/google/uuid"
 
-	
+	"kaffino/internal/logging"
 )
 
-func (s *service) GetUser(email string) (User, error) {
+// User is a customer or staff account.
+type User struct {
+	ID         string
+	Email      string
+	Subscriber sql.NullBool
+	Username   sql.NullString
+	Role       string
+	CreatedAt  sql.NullTime
+	UpdatedAt  sql.NullTime
+}
+
+func (s *service) GetUser(ctx context.Context, email string) (User, error) {
 	query := `
-		SELECT id, email, username, subscriber
+		SELECT id, email, username, subscriber, role
 		FROM users
 		WHERE email = $1
 	`
 	var user User
-	err := s.db.QueryRow(query, email).Scan(&user.ID, &user.Email, &user.Username, &user.Subscriber)
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.Username, &user.Subscriber, &user.Role)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// User not found
 			return User{}, nil // Return an empty User struct and a nil error
 		}
-		log.Println("Error retrieving user:", err)
+		logging.FromContext(ctx).Error("Error retrieving user", "error", err)
 		return User{}, err
 	}
 
 	return user, nil
 }
-func (s *service) GetUserID(email string) (string, error) {
+func (s *service) GetUserID(ctx context.Context, email string) (string, error) {
 	// Check if the user exists
 	var userID string
 	query := `
@@ -36,19 +48,19 @@ func (s *service) GetUserID(email string) (string, error) {
 		FROM users
 		WHERE email = $1
 	`
-	err := s.db.QueryRow(query, email).Scan(&userID)
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Println("Not user found... Creating new user.......")
+			logging.FromContext(ctx).Info("User not found, creating it")
 			// User doesn't exist, create a new user
-			userID, err = s.createUser(email)
+			userID, err = s.createUser(ctx, email)
 			if err != nil {
-				log.Println("Error creating user:", err)
+				logging.FromContext(ctx).Error("Error creating user", "error", err)
 				return "", err
 			}
 			return userID, nil
 		} else {
-			log.Println("Error retrieving user:", err)
+			logging.FromContext(ctx).Error("Error retrieving user", "error", err)
 			return "", err
 		}
 	}
@@ -58,7 +70,7 @@ func (s *service) GetUserID(email string) (string, error) {
 }
 
 // createUser creates a new user in the database.
-func (s *service) createUser(email string) (string, error) {
+func (s *service) createUser(ctx context.Context, email string) (string, error) {
 	// Generate a new UUID for the user ID
 	userID := uuid.New().String()
 
@@ -67,11 +79,24 @@ func (s *service) createUser(email string) (string, error) {
 		INSERT INTO users (id, email)
 		VALUES ($1, $2)
 	`
-	_, err := s.db.Exec(query, userID, email)
+	_, err := s.db.ExecContext(ctx, query, userID, email)
 	if err != nil {
-		log.Println("Database query error:", err)
+		logging.FromContext(ctx).Error("Database query error", "error", err)
 		return "", err
 	}
 
 	return userID, nil
 }
+
+// GetUserEmail retrieves the email of a user by ID.
+func (s *service) GetUserEmail(ctx context.Context, userID string) (string, error) {
+	var email string
+	err := s.db.QueryRowContext(ctx, `SELECT email FROM users WHERE id = ?`, userID).Scan(&email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrUserNotFound
+		}
+		return "", fmt.Errorf("error getting user email: %w", err)
+	}
+	return email, nil
+}
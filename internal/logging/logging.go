@@ -0,0 +1,31 @@
+// Package logging sets up the structured JSON logger of the backend and
+// carries a request scoped logger through contexts, so every line logged
+// while serving a request can be traced back to it by its request ID.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+type contextKey struct{}
+
+// New creates a logger writing JSON lines at or above level.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// WithContext returns a copy of ctx carrying logger.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger when
+// there is none, such as in background jobs.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID in requests and responses.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits the request IDs taken from clients, so they cannot
+// inject arbitrary text into the logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type requestKey struct{}
+
+// request collects what is learned about a request while it is served.
+type request struct {
+	userID string
+}
+
+// Middleware gives every request an ID, taken from the X-Request-ID header
+// when the client or proxy sent a valid one, and echoes it in the response.
+// Handlers get a logger tagged with the ID through FromContext, and each
+// request is logged once it is served.
+func Middleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(RequestIDHeader)
+			if !validRequestID.MatchString(id) {
+				id = uuid.NewString()
+			}
+			w.Header().Set(RequestIDHeader, id)
+
+			reqLogger := logger.With("request_id", id)
+			req := &request{}
+			ctx := context.WithValue(WithContext(r.Context(), reqLogger), requestKey{}, req)
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			level := slog.LevelInfo
+			if rec.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			attrs := []any{
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if req.userID != "" {
+				attrs = append(attrs, "user_id", req.userID)
+			}
+			reqLogger.Log(ctx, level, "request", attrs...)
+		})
+	}
+}
+
+// WithUserID records the user making a request, for the request log and
+// the lines logged after it through the returned context.
+func WithUserID(ctx context.Context, userID string) context.Context {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.userID = userID
+	}
+	return WithContext(ctx, FromContext(ctx).With("user_id", userID))
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush supports streaming responses.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack supports the websocket endpoint. A hijacked connection is logged
+// as 101 Switching Protocols.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	r.wroteHeader = true
+	return h.Hijack()
+}
@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(&out, slog.LevelInfo)
+
+	handler := Middleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := WithUserID(r.Context(), "user-1")
+		FromContext(ctx).Info("handling")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/coffee", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Errorf("expected the request ID to be echoed; got %q", got)
+	}
+
+	var lines []map[string]any
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatalf("error decoding log line. Err: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines; got %d", len(lines))
+	}
+	for _, line := range lines {
+		if line["request_id"] != "abc-123" || line["user_id"] != "user-1" {
+			t.Errorf("expected request and user IDs; got %v", line)
+		}
+	}
+	if access := lines[1]; access["status"] != float64(http.StatusTeapot) || access["path"] != "/coffee" || access["method"] != "GET" {
+		t.Errorf("unexpected request log %v", access)
+	}
+
+	// Unsafe IDs are replaced
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "bad\nid")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got := rec.Header().Get(RequestIDHeader); got == "" || got == "bad\nid" {
+		t.Errorf("expected a generated request ID; got %q", got)
+	}
+}
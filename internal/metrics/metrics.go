@@ -0,0 +1,35 @@
+// Package metrics serves the Prometheus metrics of the backend and times its
+// HTTP routes. Metrics are created with the Prometheus client, usually as
+// package variables registered with promauto:
+//
+//	var ordersPlaced = promauto.NewCounter(prometheus.CounterOpts{
+//		Name: "kaffino_orders_placed_total",
+//		Help: "Orders placed.",
+//	})
+//
+//	ordersPlaced.Inc()
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler serves the metrics of the default Prometheus registry. When token
+// is not empty, scrapers must send it as a bearer token.
+func Handler(token string) http.Handler {
+	metrics := promhttp.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
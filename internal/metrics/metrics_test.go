@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler("").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("error scraping metrics. Status: %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestInstrumentMux(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /product/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := InstrumentMux(mux)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/product/42", nil))
+
+	out := scrape(t)
+	want := `kaffino_http_requests_total{code="404",method="GET",route="GET /product/{id}"} 1`
+	if !strings.Contains(out, want) {
+		t.Errorf("expected metrics to contain %q; got\n%s", want, out)
+	}
+	if !strings.Contains(out, `kaffino_http_request_duration_seconds_count{method="GET",route="GET /product/{id}"} 1`) {
+		t.Errorf("expected the request to be timed; got\n%s", out)
+	}
+}
+
+func TestInstrumentMuxUnknownMethod(t *testing.T) {
+	handler := InstrumentMux(http.NewServeMux())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/pot", nil))
+
+	out := scrape(t)
+	if strings.Contains(out, `method="BREW"`) {
+		t.Errorf("expected non-standard methods not to become labels; got\n%s", out)
+	}
+	if !strings.Contains(out, `kaffino_http_requests_total{code="404",method="other",route="unmatched"} 1`) {
+		t.Errorf("expected the request to be counted as method other; got\n%s", out)
+	}
+}
+
+func TestHandlerToken(t *testing.T) {
+	handler := Handler("s3cret")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without a token; got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "# TYPE") {
+		t.Errorf("expected metrics with the token; got %d", rec.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"kaffino/internal/logging"
+)
+
+// FakeProvider is a payment provider that runs entirely in memory, for
+// development and tests. Intents are authorized as soon as they are created,
+// and captures and refunds are reported through webhooks signed like a real
+// provider's, so the whole flow can be exercised without a gateway. Intents
+// are lost when the process exits, like intents a real provider expired.
+type FakeProvider struct {
+	// WebhookURL receives the events. When empty, events are only kept for
+	// Events.
+	WebhookURL string
+	Secret     []byte
+	Client     *http.Client
+
+	mu      sync.Mutex
+	intents map[string]*Intent
+	events  []Event
+}
+
+// NewFakeProvider returns a fake provider delivering its webhooks to
+// webhookURL, signed with secret.
+func NewFakeProvider(webhookURL string, secret []byte) *FakeProvider {
+	return &FakeProvider{
+		WebhookURL: webhookURL,
+		Secret:     secret,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+		intents:    make(map[string]*Intent),
+	}
+}
+
+// Name identifies the fake provider.
+func (p *FakeProvider) Name() string {
+	return ProviderFake
+}
+
+// CreateIntent creates an intent ready to be captured.
+func (p *FakeProvider) CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error) {
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("payment amount must be positive: %d", req.Amount)
+	}
+	id := "fake_pi_" + uuid.New().String()
+	intent := &Intent{
+		ID:           id,
+		OrderID:      req.OrderID,
+		Amount:       req.Amount,
+		Currency:     req.Currency,
+		Status:       IntentRequiresCapture,
+		ClientSecret: id + "_secret_" + uuid.New().String(),
+	}
+
+	p.mu.Lock()
+	p.intents[id] = intent
+	p.mu.Unlock()
+
+	copied := *intent
+	return &copied, nil
+}
+
+// GetIntent returns an intent created since the provider started.
+func (p *FakeProvider) GetIntent(ctx context.Context, intentID string) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+	copied := *intent
+	return &copied, nil
+}
+
+// Capture collects an intent and sends a payment.succeeded webhook.
+func (p *FakeProvider) Capture(ctx context.Context, intentID string) (*Intent, error) {
+	return p.settle(ctx, intentID, IntentRequiresCapture, IntentSucceeded, EventPaymentSucceeded)
+}
+
+// Fail declines an intent and sends a payment.failed webhook.
+func (p *FakeProvider) Fail(ctx context.Context, intentID string) (*Intent, error) {
+	return p.settle(ctx, intentID, IntentRequiresCapture, IntentFailed, EventPaymentFailed)
+}
+
+// Refund refunds a captured intent and sends a payment.refunded webhook.
+func (p *FakeProvider) Refund(ctx context.Context, intentID string) (*Intent, error) {
+	return p.settle(ctx, intentID, IntentSucceeded, IntentRefunded, EventPaymentRefunded)
+}
+
+// settle moves an intent from one status to another and reports it.
+func (p *FakeProvider) settle(ctx context.Context, intentID string, from, to IntentStatus, eventType EventType) (*Intent, error) {
+	p.mu.Lock()
+	intent, ok := p.intents[intentID]
+	if !ok {
+		p.mu.Unlock()
+		return nil, ErrIntentNotFound
+	}
+	if intent.Status != from {
+		status := intent.Status
+		p.mu.Unlock()
+		return nil, fmt.Errorf("%w: intent is %s", ErrInvalidIntentState, status)
+	}
+	intent.Status = to
+	copied := *intent
+	event := Event{
+		ID:       "fake_evt_" + uuid.New().String(),
+		Type:     eventType,
+		IntentID: intent.ID,
+		OrderID:  intent.OrderID,
+		Amount:   intent.Amount,
+		Currency: intent.Currency,
+		Created:  time.Now().UTC(),
+	}
+	p.events = append(p.events, event)
+	p.mu.Unlock()
+
+	// Like a real provider, the intent is settled even when the webhook
+	// does not get through
+	if err := p.deliver(ctx, event); err != nil {
+		logging.FromContext(ctx).Warn("Failed to deliver payment webhook", "event", event.ID, "error", err)
+	}
+	return &copied, nil
+}
+
+// deliver posts a signed event to the webhook URL.
+func (p *FakeProvider) deliver(ctx context.Context, event Event) error {
+	if p.WebhookURL == "" {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(p.Secret, time.Now(), body))
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Events returns the events sent so far, oldest first.
+func (p *FakeProvider) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Event(nil), p.events...)
+}
@@ -0,0 +1,76 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFakeProvider(t *testing.T) {
+	secret := []byte("whsec")
+	received := make(chan *Event, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		event, err := ParseEvent(secret, r.Header.Get(SignatureHeader), body, time.Now())
+		if err != nil {
+			t.Errorf("webhook did not verify: %v", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		received <- event
+	}))
+	defer hook.Close()
+
+	ctx := context.Background()
+	p := NewFakeProvider(hook.URL, secret)
+
+	if _, err := p.CreateIntent(ctx, IntentRequest{OrderID: "o1", Currency: CurrencyPEN}); err == nil {
+		t.Error("expected an intent without amount to be rejected")
+	}
+
+	intent, err := p.CreateIntent(ctx, IntentRequest{OrderID: "o1", Amount: 3550, Currency: CurrencyPEN})
+	if err != nil {
+		t.Fatalf("error creating intent. Err: %v", err)
+	}
+	if intent.Status != IntentRequiresCapture || intent.ClientSecret == "" {
+		t.Errorf("unexpected new intent %+v", intent)
+	}
+	if got, err := p.GetIntent(ctx, intent.ID); err != nil || got.Status != IntentRequiresCapture {
+		t.Errorf("expected to look up the new intent; got %+v, %v", got, err)
+	}
+
+	if _, err := p.Refund(ctx, intent.ID); !errors.Is(err, ErrInvalidIntentState) {
+		t.Errorf("expected refunding an uncaptured intent to fail; got %v", err)
+	}
+
+	if intent, err = p.Capture(ctx, intent.ID); err != nil || intent.Status != IntentSucceeded {
+		t.Fatalf("expected a captured intent; got %+v, %v", intent, err)
+	}
+	if event := <-received; event.Type != EventPaymentSucceeded || event.OrderID != "o1" || event.Amount != 3550 {
+		t.Errorf("unexpected capture event %+v", event)
+	}
+	if _, err := p.Capture(ctx, intent.ID); !errors.Is(err, ErrInvalidIntentState) {
+		t.Errorf("expected a second capture to fail; got %v", err)
+	}
+
+	if intent, err = p.Refund(ctx, intent.ID); err != nil || intent.Status != IntentRefunded {
+		t.Fatalf("expected a refunded intent; got %+v, %v", intent, err)
+	}
+	if event := <-received; event.Type != EventPaymentRefunded {
+		t.Errorf("unexpected refund event %+v", event)
+	}
+
+	if _, err := p.Capture(ctx, "missing"); !errors.Is(err, ErrIntentNotFound) {
+		t.Errorf("expected an unknown intent to fail; got %v", err)
+	}
+	if _, err := NewFakeProvider("", secret).GetIntent(ctx, intent.ID); !errors.Is(err, ErrIntentNotFound) {
+		t.Errorf("expected a restarted provider not to know the intent; got %v", err)
+	}
+	if n := len(p.Events()); n != 2 {
+		t.Errorf("expected 2 events; got %d", n)
+	}
+}
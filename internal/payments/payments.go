@@ -0,0 +1,114 @@
+// Package payments talks to payment providers. A provider holds a payment
+// intent for each order: it is created when the customer checks out,
+// captured to collect the money and refunded to give it back. Providers
+// report the outcome through signed webhooks, which are the only thing that
+// marks an order as paid.
+//
+// Amounts are in the smallest unit of the currency, céntimos for soles.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// CurrencyPEN is the currency of every payment, Peruvian soles.
+const CurrencyPEN = "PEN"
+
+// ProviderFake names the FakeProvider.
+const ProviderFake = "fake"
+
+var (
+	// ErrIntentNotFound is returned when a provider does not know an intent.
+	ErrIntentNotFound = errors.New("payment intent not found")
+	// ErrInvalidIntentState is returned when an intent cannot be captured or
+	// refunded in its current status.
+	ErrInvalidIntentState = errors.New("invalid payment intent state")
+)
+
+// PaymentProvider creates and settles payment intents with a payment
+// gateway.
+type PaymentProvider interface {
+	// Name identifies the provider in stored payments and webhooks.
+	Name() string
+	// CreateIntent starts a payment for an order.
+	CreateIntent(ctx context.Context, req IntentRequest) (*Intent, error)
+	// GetIntent looks up an intent, returning ErrIntentNotFound when the
+	// provider no longer knows it.
+	GetIntent(ctx context.Context, intentID string) (*Intent, error)
+	// Capture collects the money of an authorized intent.
+	Capture(ctx context.Context, intentID string) (*Intent, error)
+	// Refund gives back the money of a captured intent.
+	Refund(ctx context.Context, intentID string) (*Intent, error)
+}
+
+// NewProvider builds the named provider. Webhooks are delivered to
+// webhookURL by providers that let it be chosen, and signed with secret.
+func NewProvider(name, webhookURL string, secret []byte) (PaymentProvider, error) {
+	switch name {
+	case ProviderFake, "":
+		return NewFakeProvider(webhookURL, secret), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", name)
+	}
+}
+
+// IntentStatus is a step in the lifecycle of a payment intent.
+type IntentStatus string
+
+const (
+	IntentRequiresCapture IntentStatus = "requires_capture"
+	IntentSucceeded       IntentStatus = "succeeded"
+	IntentFailed          IntentStatus = "failed"
+	IntentRefunded        IntentStatus = "refunded"
+	// IntentExpired marks a stored payment whose intent the provider no
+	// longer knows. It is never reported by a provider.
+	IntentExpired IntentStatus = "expired"
+)
+
+// IntentRequest asks a provider for a new payment intent.
+type IntentRequest struct {
+	OrderID  string
+	Amount   int64
+	Currency string
+}
+
+// Intent is a payment as the provider sees it.
+type Intent struct {
+	ID       string
+	OrderID  string
+	Amount   int64
+	Currency string
+	Status   IntentStatus
+	// ClientSecret lets the customer's browser complete the payment with
+	// the provider.
+	ClientSecret string
+}
+
+// EventType names what a webhook reports.
+type EventType string
+
+const (
+	EventPaymentSucceeded EventType = "payment.succeeded"
+	EventPaymentFailed    EventType = "payment.failed"
+	EventPaymentRefunded  EventType = "payment.refunded"
+)
+
+// Event is the body of a webhook.
+type Event struct {
+	ID       string    `json:"id"`
+	Type     EventType `json:"type"`
+	IntentID string    `json:"intent_id"`
+	OrderID  string    `json:"order_id"`
+	Amount   int64     `json:"amount"`
+	Currency string    `json:"currency"`
+	Created  time.Time `json:"created"`
+}
+
+// Cents converts an amount in soles to céntimos.
+func Cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
@@ -0,0 +1,60 @@
+package payments
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	secret := []byte("whsec")
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1700000000, 0)
+	header := Sign(secret, now, body)
+
+	if err := VerifySignature(secret, header, body, now.Add(time.Minute)); err != nil {
+		t.Errorf("expected a valid signature; got %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		secret []byte
+		header string
+		body   []byte
+		now    time.Time
+		want   error
+	}{
+		{"tampered body", secret, header, []byte(`{"id":"evt_2"}`), now, ErrInvalidSignature},
+		{"wrong secret", []byte("other"), header, body, now, ErrInvalidSignature},
+		{"missing header", secret, "", body, now, ErrInvalidSignature},
+		{"no signature", secret, "t=1700000000", body, now, ErrInvalidSignature},
+		{"replayed", secret, header, body, now.Add(SignatureTolerance + time.Second), ErrSignatureExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.secret, tt.header, tt.body, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v; got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	secret := []byte("whsec")
+	now := time.Now()
+
+	body := []byte(`{"id":"evt_1","type":"payment.succeeded","intent_id":"pi_1","amount":3550}`)
+	event, err := ParseEvent(secret, Sign(secret, now, body), body, now)
+	if err != nil {
+		t.Fatalf("error parsing event. Err: %v", err)
+	}
+	if event.Type != EventPaymentSucceeded || event.IntentID != "pi_1" || event.Amount != 3550 {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	body = []byte(`{"id":"evt_1"}`)
+	if _, err := ParseEvent(secret, Sign(secret, now, body), body, now); err == nil {
+		t.Error("expected an event without type to be rejected")
+	}
+}
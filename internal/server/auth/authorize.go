@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+)
+
+const roleContextKey contextKey = "role"
+
+// RoleLookup finds the role of a logged-in user. database.Service implements it.
+type RoleLookup interface {
+	GetUserRole(ctx context.Context, userID string) (database.Role, error)
+}
+
+// RoleFromContext returns the role stored by RequireRole.
+func RoleFromContext(ctx context.Context) (database.Role, bool) {
+	role, ok := ctx.Value(roleContextKey).(database.Role)
+	return role, ok
+}
+
+// RequireRole returns middleware that only lets through logged-in users
+// whose role includes the given one. Guests get a 401 and users without
+// the role a 403, both as JSON. The role is looked up on every request so
+// a demotion takes effect immediately.
+//
+// It must run inside SessionMiddleware, which puts the user ID in the context.
+func RequireRole(roles RoleLookup, role database.Role) func(http.HandlerFunc) http.Handler {
+	return func(next http.HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := UserIDFromContext(r.Context())
+			if !ok || IsGuest(userID) {
+				jsonResponse(w, http.StatusUnauthorized, response{Success: false, Error: "Login required"})
+				return
+			}
+
+			userRole, err := roles.GetUserRole(r.Context(), userID)
+			if err != nil {
+				if errors.Is(err, database.ErrUserNotFound) {
+					jsonResponse(w, http.StatusUnauthorized, response{Success: false, Error: "Login required"})
+					return
+				}
+				logging.FromContext(r.Context()).Error("Error getting user role", "error", err)
+				jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: "Failed to authorize request"})
+				return
+			}
+			if !userRole.Includes(role) {
+				jsonResponse(w, http.StatusForbidden, response{Success: false, Error: "Forbidden"})
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), roleContextKey, userRole)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kaffino/internal/config"
+)
+
+// EmailSender delivers the emails the login flow sends.
+type EmailSender interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// NewEmailSender builds the backend chosen by the configuration.
+func NewEmailSender(ctx context.Context, cfg config.Email) (EmailSender, error) {
+	from := cfg.From
+	if from == "" {
+		from = config.Default().Email.From
+	}
+
+	switch cfg.Backend {
+	case config.EmailBackendSES, "":
+		client, err := NewSESV2Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &SESSender{Client: client, From: from}, nil
+	case config.EmailBackendSMTP:
+		if cfg.SMTPHost == "" {
+			return nil, fmt.Errorf("the smtp email backend needs SMTP_HOST")
+		}
+		port := cfg.SMTPPort
+		if port == 0 {
+			port = 587
+		}
+		return &SMTPSender{
+			Addr:     net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(port)),
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     from,
+		}, nil
+	case config.EmailBackendFile:
+		if cfg.Dir == "" {
+			return nil, fmt.Errorf("the file email backend needs EMAIL_DIR")
+		}
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, fmt.Errorf("error creating email directory: %w", err)
+		}
+		return &DevSender{Dir: cfg.Dir, From: from}, nil
+	case config.EmailBackendStdout:
+		return &DevSender{Out: os.Stdout, From: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown email backend %q", cfg.Backend)
+	}
+}
+
+// CheckEmailSender reports whether sender is set up to deliver: it exists,
+// has a sender address, and has the client, server or destination its
+// backend delivers through. It does not contact the backend.
+func CheckEmailSender(sender EmailSender) error {
+	switch s := sender.(type) {
+	case nil:
+		return fmt.Errorf("no email backend configured")
+	case *SESSender:
+		if s == nil || s.Client == nil {
+			return fmt.Errorf("no SES client configured")
+		}
+		if s.From == "" {
+			return fmt.Errorf("no sender address configured")
+		}
+	case *SMTPSender:
+		if s == nil || s.Addr == "" {
+			return fmt.Errorf("no SMTP server configured")
+		}
+		if s.From == "" {
+			return fmt.Errorf("no sender address configured")
+		}
+	case *DevSender:
+		if s == nil || (s.Dir == "" && s.Out == nil) {
+			return fmt.Errorf("no email destination configured")
+		}
+		if s.Dir != "" {
+			if info, err := os.Stat(s.Dir); err != nil || !info.IsDir() {
+				return fmt.Errorf("email directory %s is missing", s.Dir)
+			}
+		}
+	}
+	return nil
+}
+
+// SMTPSender sends emails through an SMTP server, authenticating with PLAIN
+// auth when a username is set.
+type SMTPSender struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send sends an email through the SMTP server.
+func (s *SMTPSender) Send(ctx context.Context, to, subject, body string) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	msg, err := formatMessage(s.From, to, subject, body, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(s.Addr, auth, s.From, []string{to}, msg); err != nil {
+		return fmt.Errorf("error sending email with SMTP: %w", err)
+	}
+	return nil
+}
+
+// DevSender is for local development. It writes each message to a file in
+// Dir, or to Out when Dir is empty, instead of delivering it.
+type DevSender struct {
+	Dir  string
+	Out  io.Writer
+	From string
+
+	mu sync.Mutex
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._@-]+`)
+
+// Send writes the message out.
+func (s *DevSender) Send(ctx context.Context, to, subject, body string) error {
+	now := time.Now()
+	msg, err := formatMessage(s.From, to, subject, body, now)
+	if err != nil {
+		return err
+	}
+
+	if s.Dir == "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, err := fmt.Fprintf(s.Out, "%s\n", msg); err != nil {
+			return fmt.Errorf("error writing email: %w", err)
+		}
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405.000000000"), unsafeFileChars.ReplaceAllString(to, "_"))
+	if err := os.WriteFile(filepath.Join(s.Dir, name), msg, 0o644); err != nil {
+		return fmt.Errorf("error writing email: %w", err)
+	}
+	return nil
+}
+
+// formatMessage builds a plain text RFC 5322 message. Addresses with line
+// breaks are rejected so they cannot inject headers.
+func formatMessage(from, to, subject, body string, date time.Time) ([]byte, error) {
+	if strings.ContainsAny(from+to, "\r\n") {
+		return nil, fmt.Errorf("invalid email address %q", to)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	b.WriteString("\r\n")
+	return []byte(b.String()), nil
+}
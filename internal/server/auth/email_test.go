@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+
+	"kaffino/internal/config"
+)
+
+type fakeSES struct {
+	input *sesv2.SendEmailInput
+}
+
+func (f *fakeSES) SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error) {
+	f.input = params
+	return &sesv2.SendEmailOutput{MessageId: aws.String("msg-1")}, nil
+}
+
+func TestSESSender(t *testing.T) {
+	client := &fakeSES{}
+	sender := &SESSender{Client: client, From: "shop@example.com"}
+	if err := sender.Send(context.Background(), "ana@example.com", "Your code", "123456"); err != nil {
+		t.Fatalf("error sending email. Err: %v", err)
+	}
+
+	if got := aws.ToString(client.input.FromEmailAddress); got != "shop@example.com" {
+		t.Errorf("expected from shop@example.com; got %s", got)
+	}
+	if got := client.input.Destination.ToAddresses; len(got) != 1 || got[0] != "ana@example.com" {
+		t.Errorf("expected to ana@example.com; got %v", got)
+	}
+}
+
+func TestDevSender(t *testing.T) {
+	var out bytes.Buffer
+	sender := &DevSender{Out: &out, From: "shop@example.com"}
+	if err := sender.Send(context.Background(), "ana@example.com", "Your code", "Your OTP is: 123456"); err != nil {
+		t.Fatalf("error sending email. Err: %v", err)
+	}
+	for _, want := range []string{"To: ana@example.com\r\n", "Subject: Your code\r\n", "Your OTP is: 123456"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected message to contain %q; got %q", want, out.String())
+		}
+	}
+
+	dir := t.TempDir()
+	sender = &DevSender{Dir: dir, From: "shop@example.com"}
+	if err := sender.Send(context.Background(), "ana@example.com", "Your code", "123456"); err != nil {
+		t.Fatalf("error sending email. Err: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one message file; got %v", files)
+	}
+	if body, _ := os.ReadFile(files[0]); !bytes.Contains(body, []byte("123456")) {
+		t.Errorf("expected message file to contain the body; got %q", body)
+	}
+
+	if err := sender.Send(context.Background(), "ana@example.com\r\nBcc: eve@example.com", "Hi", "x"); err == nil {
+		t.Error("expected an address with a line break to be rejected")
+	}
+}
+
+func TestNewEmailSender(t *testing.T) {
+	if _, err := NewEmailSender(context.Background(), config.Email{Backend: "pigeon"}); err == nil {
+		t.Error("expected unknown backend to be rejected")
+	}
+	if _, err := NewEmailSender(context.Background(), config.Email{Backend: config.EmailBackendSMTP}); err == nil {
+		t.Error("expected smtp backend without a host to be rejected")
+	}
+	sender, err := NewEmailSender(context.Background(), config.Email{Backend: config.EmailBackendFile, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("error creating file sender. Err: %v", err)
+	}
+	if _, ok := sender.(*DevSender); !ok {
+		t.Errorf("expected a DevSender; got %T", sender)
+	}
+}
+
+func TestCheckEmailSender(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		sender EmailSender
+		ok     bool
+	}{
+		{"none", nil, false},
+		{"ses", &SESSender{Client: &fakeSES{}, From: "shop@example.com"}, true},
+		{"ses without client", &SESSender{From: "shop@example.com"}, false},
+		{"smtp", &SMTPSender{Addr: "smtp.example.com:587", From: "shop@example.com"}, true},
+		{"smtp without server", &SMTPSender{From: "shop@example.com"}, false},
+		{"smtp without sender", &SMTPSender{Addr: "smtp.example.com:587"}, false},
+		{"file", &DevSender{Dir: dir, From: "shop@example.com"}, true},
+		{"file in a missing directory", &DevSender{Dir: filepath.Join(dir, "gone"), From: "shop@example.com"}, false},
+		{"stdout", &DevSender{Out: &bytes.Buffer{}, From: "shop@example.com"}, true},
+	}
+	for _, tt := range tests {
+		if err := CheckEmailSender(tt.sender); (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok %v; got %v", tt.name, tt.ok, err)
+		}
+	}
+}
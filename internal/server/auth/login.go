@@ -3,12 +3,16 @@ package auth
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/gorilla/sessions"
+
+	"kaffino/internal/config"
 	"kaffino/internal/database"
+	"kaffino/internal/logging"
 )
 
 const lockoutDuration = 5 * time.Minute
@@ -20,14 +24,40 @@ type response struct {
 	Error   string      `json:"error,omitempty"`
 }
 
-var (
-	failedLogins = make(map[string]loginAttempt)
-)
+// Handlers serves the login endpoints.
+type Handlers struct {
+	db         database.Service
+	challenges ChallengeStore
+	sessions   SessionStore
+	store      *DBStore
+	mailer     EmailSender
+
+	// key signs login codes and login links.
+	key []byte
+
+	// LoginLinkURL is the public base URL of the API, such as
+	// https://kaffino.pe/api/v1. When set, login emails also carry a
+	// one-click login link.
+	LoginLinkURL string
+}
 
-type loginAttempt struct {
-	Attempts    int
-	Lockout     time.Time
-	LastAttempt time.Time
+// NewHandlers creates the login handlers. Login codes are kept in
+// challenges and sent through mailer, and sessions are kept in sessions.
+func NewHandlers(cfg *config.Config, db database.Service, challenges ChallengeStore, sessions SessionStore, mailer EmailSender) *Handlers {
+	key := []byte(cfg.SessionKey)
+	store := NewDBStore(sessions, key)
+	store.Options.Secure = cfg.Secure()
+	store.TrustedProxies = cfg.TrustedProxyRanges()
+
+	return &Handlers{
+		db:           db,
+		challenges:   challenges,
+		sessions:     sessions,
+		store:        store,
+		mailer:       mailer,
+		key:          key,
+		LoginLinkURL: cfg.LoginLinkURL,
+	}
 }
 
 type loginRequest struct {
@@ -39,12 +69,30 @@ func jsonResponse(w http.ResponseWriter, status int, resp response) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		log.Printf("Error encoding JSON response: %v", err)
+		slog.Error("Error encoding JSON response", "error", err)
 	}
 }
 
-func VerifyOTPHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := store.Get(r, "session-name")
+// lockedOut writes a 429 response and returns true when the email is locked
+// out after too many failed logins.
+func (h *Handlers) lockedOut(w http.ResponseWriter, r *http.Request, email string) bool {
+	lockedUntil, err := h.challenges.LoginLockedUntil(r.Context(), email, time.Now())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error checking login lockout", "error", err)
+		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: "Failed to check login attempts"})
+		return true
+	}
+	if lockedUntil.IsZero() {
+		return false
+	}
+
+	remaining := time.Until(lockedUntil).Round(time.Second).String()
+	jsonResponse(w, http.StatusTooManyRequests, response{Success: false, Error: fmt.Sprintf("Too many failed attempts. Please try again in %s", remaining)})
+	return true
+}
+
+func (h *Handlers) VerifyOTPHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := h.store.Get(r, "session-name")
 	if err != nil {
 		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: err.Error()})
 		return
@@ -65,58 +113,75 @@ func VerifyOTPHandler(w http.ResponseWriter, r *http.Request) {
 	email := req.Email
 	otp := req.OTP
 
-	attempt, ok := failedLogins[email]
-	if ok && attempt.Lockout.After(time.Now()) {
-		remaining := time.Until(attempt.Lockout).String()
-		jsonResponse(w, http.StatusTooManyRequests, response{Success: false, Error: fmt.Sprintf("Too many failed attempts. Please try again in %s", remaining)})
+	if h.lockedOut(w, r, email) {
 		return
 	}
 
-	storedOTP := RetrieveOTP(email)
-
-	if storedOTP == "" {
-		jsonResponse(w, http.StatusBadRequest, response{Success: false, Error: "Invalid OTP"})
+	valid, err := h.challenges.ConsumeLoginChallenge(r.Context(), email, hashOTP(h.key, email, otp), time.Now())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error checking OTP", "error", err)
+		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: "Failed to verify OTP"})
 		return
 	}
-	log.Println(storedOTP)
-
-	if otp != storedOTP {
-		attempt.Attempts++
-		attempt.LastAttempt = time.Now()
-		if attempt.Attempts >= 5 {
-			attempt.Lockout = time.Now().Add(lockoutDuration)
+	if !valid {
+		loginFailures.Inc()
+		lockedUntil, err := h.challenges.RecordFailedLogin(r.Context(), email, time.Now(), maxFailedLogins, lockoutDuration)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Error recording failed login", "error", err)
+		}
+		if !lockedUntil.IsZero() {
+			loginLockouts.Inc()
 			jsonResponse(w, http.StatusTooManyRequests, response{Success: false, Error: "Too many failed attempts. Account locked for 5 minutes."})
-			failedLogins[email] = attempt
 			return
 		}
-		failedLogins[email] = attempt
 		jsonResponse(w, http.StatusBadRequest, response{Success: false, Error: "Invalid OTP"})
 		return
 	}
 
 	// Reset failed attempts on successful login
-	delete(failedLogins, email)
-
-	db := database.NewDB()
-	userID, err := db.GetUserID(email)
-	if err != nil {
-		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: err.Error()})
-		return
+	if err := h.challenges.ResetFailedLogins(r.Context(), email); err != nil {
+		logging.FromContext(r.Context()).Error("Error resetting failed logins", "error", err)
 	}
-	session.Values["userID"] = userID
-	session.Values["username"] = email // Store the user ID in the session
-	err = session.Save(r, w)
+
+	userID, err := h.startSession(w, r, session, email)
 	if err != nil {
 		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: err.Error()})
 		return
 	}
+	logins.WithLabelValues("otp").Inc()
 
 	// Return success response
 	jsonResponse(w, http.StatusOK, response{Success: true, Message: "Login successful", Data: map[string]interface{}{"userID": userID}})
 }
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := store.Get(r, "session-name")
+// startSession promotes the session to the user with the given email,
+// creating the user if needed, and returns their user ID.
+func (h *Handlers) startSession(w http.ResponseWriter, r *http.Request, session *sessions.Session, email string) (string, error) {
+	userID, err := h.db.GetUserID(r.Context(), email)
+	if err != nil {
+		return "", err
+	}
+
+	// Carry the guest cart over to the user's account
+	if guestID, ok := session.Values["userID"].(string); ok && IsGuest(guestID) {
+		if err := h.db.MergeCarts(r.Context(), guestID, userID); err != nil {
+			logging.FromContext(r.Context()).Error("Error merging guest cart", "error", err)
+		}
+	}
+	// A new session ID on login, so one planted before login is worthless
+	if err := h.store.renew(r, session); err != nil {
+		return "", err
+	}
+	session.Values["userID"] = userID
+	session.Values["username"] = email // Store the user ID in the session
+	if err := session.Save(r, w); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+func (h *Handlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := h.store.Get(r, "session-name")
 	if err != nil {
 		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: err.Error()})
 		return
@@ -141,10 +206,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	email := req.Email
 
-	attempt, ok := failedLogins[email]
-	if ok && attempt.Lockout.After(time.Now()) {
-		remaining := time.Until(attempt.Lockout).String()
-		jsonResponse(w, http.StatusTooManyRequests, response{Success: false, Error: fmt.Sprintf("Too many failed attempts. Please try again in %s", remaining)})
+	if h.lockedOut(w, r, email) {
 		return
 	}
 
@@ -154,19 +216,36 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	StoreOTP(email, otp)
+	err = h.challenges.SaveLoginChallenge(r.Context(), email, hashOTP(h.key, email, otp), time.Now().Add(otpExpiration))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error saving OTP", "error", err)
+		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: "Failed to generate OTP"})
+		return
+	}
+
+	link, err := h.loginLink(r, email)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error creating login link", "error", err)
+		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: "Failed to generate OTP"})
+		return
+	}
 
 	subject := "Your OTP for Login"
 	body := fmt.Sprintf("Your OTP is: %s", otp)
+	if link != "" {
+		body += fmt.Sprintf("\n\nOr log in with this link, valid for %d minutes: %s", int(loginLinkExpiration.Minutes()), link)
+	}
 
-	err = SendEmail(email, subject, body)
+	err = h.mailer.Send(r.Context(), email, subject, body)
 	if err != nil {
-		log.Printf("Error sending email: %v", err)
+		otpSendFailures.Inc()
+		logging.FromContext(r.Context()).Error("Error sending email", "error", err)
 		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: "Failed to send email, try again later."})
 		return
 	}
 
-	fmt.Println("OTP sent to:", email)
+	otpSent.Inc()
+	logging.FromContext(r.Context()).Info("OTP sent", "email", email)
 
 	// Return success response
 	jsonResponse(w, http.StatusOK, response{Success: true, Message: "OTP sent successfully", Data: map[string]string{"email": email}})
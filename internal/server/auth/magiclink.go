@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"kaffino/internal/logging"
+)
+
+// loginLinkExpiration is how long an emailed login link works.
+const loginLinkExpiration = 15 * time.Minute
+
+var errInvalidLoginLink = errors.New("invalid login link")
+
+// newLoginLinkToken creates the token of a login link. It holds a random
+// nonce and its expiry, signed with the session key, so forged or expired
+// tokens are turned away before touching the database.
+func newLoginLinkToken(key []byte, expiresAt time.Time) (string, error) {
+	payload := make([]byte, 24)
+	if _, err := rand.Read(payload[:16]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint64(payload[16:], uint64(expiresAt.Unix()))
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(signLoginLink(key, payload)), nil
+}
+
+// verifyLoginLinkToken checks the signature and expiry of a login link token.
+func verifyLoginLinkToken(key []byte, token string, now time.Time) error {
+	enc := base64.RawURLEncoding
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return errInvalidLoginLink
+	}
+	payload, err := enc.DecodeString(encPayload)
+	if err != nil || len(payload) != 24 {
+		return errInvalidLoginLink
+	}
+	sig, err := enc.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, signLoginLink(key, payload)) {
+		return errInvalidLoginLink
+	}
+	if expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload[16:])), 0); !now.Before(expiresAt) {
+		return errInvalidLoginLink
+	}
+	return nil
+}
+
+func signLoginLink(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("login-link"))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// hashLoginLink hashes a token for storage, so the stored hashes cannot be
+// used as links.
+func hashLoginLink(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// loginLink creates a single-use login link for an email. It returns an
+// empty link when login links are not configured.
+func (h *Handlers) loginLink(r *http.Request, email string) (string, error) {
+	if h.LoginLinkURL == "" {
+		return "", nil
+	}
+
+	expiresAt := time.Now().Add(loginLinkExpiration)
+	token, err := newLoginLinkToken(h.key, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	if err := h.challenges.SaveLoginLink(r.Context(), hashLoginLink(token), email, expiresAt); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(h.LoginLinkURL, "/") + "/login/verify?token=" + url.QueryEscape(token), nil
+}
+
+// VerifyLoginLinkHandler logs in the user a login link was sent to and
+// redirects to the shop. Invalid, used or expired links redirect to the
+// login page instead.
+func (h *Handlers) VerifyLoginLinkHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := h.store.Get(r, "session-name")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if err := verifyLoginLinkToken(h.key, token, time.Now()); err != nil {
+		http.Redirect(w, r, "/login?error=invalid_link", http.StatusSeeOther)
+		return
+	}
+
+	email, ok, err := h.challenges.ConsumeLoginLink(r.Context(), hashLoginLink(token), time.Now())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error checking login link", "error", err)
+		http.Error(w, "Failed to verify login link", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Redirect(w, r, "/login?error=invalid_link", http.StatusSeeOther)
+		return
+	}
+
+	if _, err := h.startSession(w, r, session, email); err != nil {
+		logging.FromContext(r.Context()).Error("Error logging in with link", "error", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	logins.WithLabelValues("link").Inc()
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginLinkToken(t *testing.T) {
+	key := []byte("test-key")
+	now := time.Now()
+	token, err := newLoginLinkToken(key, now.Add(loginLinkExpiration))
+	if err != nil {
+		t.Fatalf("error creating token. Err: %v", err)
+	}
+
+	if err := verifyLoginLinkToken(key, token, now); err != nil {
+		t.Errorf("expected token to be valid; got %v", err)
+	}
+	if err := verifyLoginLinkToken(key, token, now.Add(loginLinkExpiration+time.Second)); err == nil {
+		t.Error("expected expired token to be rejected")
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	first := "A"
+	if payload[0] == 'A' {
+		first = "B"
+	}
+	if err := verifyLoginLinkToken(key, first+payload[1:]+"."+sig, now); err == nil {
+		t.Error("expected tampered token to be rejected")
+	}
+	for _, bad := range []string{"", "abc", "abc.def", payload + "."} {
+		if err := verifyLoginLinkToken(key, bad, now); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
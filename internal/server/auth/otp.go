@@ -1,10 +1,17 @@
 package auth
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
 	"math/big"
-	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 // GenerateOTP generates a random OTP of the specified length.
@@ -24,33 +31,79 @@ func GenerateOTP(length int) (string, error) {
 	return otp, nil
 }
 
-// OTPData stores the OTP and the creation time.
-type OTPData struct {
-	OTP       string
-	CreatedAt time.Time
+// ChallengeStore keeps the login codes and links that are waiting to be
+// used and the failed login counters. database.Service implements it on SQLite so
+// every backend replica shares them.
+type ChallengeStore interface {
+	SaveLoginChallenge(ctx context.Context, email, codeHash string, expiresAt time.Time) error
+	ConsumeLoginChallenge(ctx context.Context, email, codeHash string, now time.Time) (bool, error)
+	LoginLockedUntil(ctx context.Context, email string, now time.Time) (time.Time, error)
+	RecordFailedLogin(ctx context.Context, email string, now time.Time, maxAttempts int, lockout time.Duration) (time.Time, error)
+	ResetFailedLogins(ctx context.Context, email string) error
+	DeleteExpiredLoginChallenges(ctx context.Context, before time.Time) (int64, error)
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) error
+	SaveLoginLink(ctx context.Context, tokenHash, email string, expiresAt time.Time) error
+	ConsumeLoginLink(ctx context.Context, tokenHash string, now time.Time) (string, bool, error)
 }
 
+const (
+	otpExpiration   = 5 * time.Minute // OTP expires after 5 minutes
+	maxFailedLogins = 5
+	// failedLoginWindow is how long failed logins count toward a lockout.
+	failedLoginWindow = 24 * time.Hour
+)
+
 var (
-	otpStorage    = make(map[string]OTPData)
-	otpStorageMu  sync.Mutex
-	otpExpiration = 5 * time.Minute // OTP expires after 5 minutes
+	otpSent = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kaffino_otp_sent_total",
+		Help: "Login codes emailed.",
+	})
+	otpSendFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kaffino_otp_send_failures_total",
+		Help: "Login codes that could not be emailed.",
+	})
+	loginFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kaffino_login_failures_total",
+		Help: "Logins rejected for a wrong or expired code.",
+	})
+	loginLockouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kaffino_login_lockouts_total",
+		Help: "Emails locked out after too many failed logins.",
+	})
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kaffino_logins_total",
+		Help: "Successful logins, by method.",
+	}, []string{"method"})
 )
 
-// StoreOTP stores the OTP for the given email.
-func StoreOTP(email, otp string) {
-	otpStorageMu.Lock()
-	defer otpStorageMu.Unlock()
-	otpStorage[email] = OTPData{OTP: otp, CreatedAt: time.Now()}
+// hashOTP hashes a code for storage. It is keyed with the session key so the
+// stored hashes of six digit codes cannot be reversed by trying them all.
+func hashOTP(key []byte, email, otp string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(email))
+	mac.Write([]byte{0})
+	mac.Write([]byte(otp))
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
-// RetrieveOTP retrieves the OTP for the given email.  Returns an empty string if not found or expired.
-func RetrieveOTP(email string) string {
-	otpStorageMu.Lock()
-	defer otpStorageMu.Unlock()
-	data, ok := otpStorage[email]
-	if !ok || time.Since(data.CreatedAt) > otpExpiration {
-		delete(otpStorage, email) // Remove expired OTP
-		return ""
+// SweepChallenges periodically removes expired login codes and stale failed
+// login counters.
+func (h *Handlers) SweepChallenges(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		n, err := h.challenges.DeleteExpiredLoginChallenges(context.Background(), now)
+		if err != nil {
+			slog.Error("Failed to delete expired login challenges", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("Deleted expired login challenges", "count", n)
+		}
+		if err := h.challenges.DeleteStaleLoginAttempts(context.Background(), now.Add(-failedLoginWindow)); err != nil {
+			slog.Error("Failed to delete stale login attempts", "error", err)
+		}
 	}
-	return data.OTP
 }
@@ -3,12 +3,13 @@ package auth
 import (
 	"context"
 	"fmt"
-	"log"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2"
 	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+
+	"kaffino/internal/logging"
 )
 
 // SESV2API defines the interface for the SESV2 client.  This allows us to mock it in tests.
@@ -16,9 +17,14 @@ type SESV2API interface {
 	SendEmail(ctx context.Context, params *sesv2.SendEmailInput, optFns ...func(*sesv2.Options)) (*sesv2.SendEmailOutput, error)
 }
 
-// SendEmailAWS sends an email using AWS SES V2.
-func SendEmailAWS(svc SESV2API, to, subject, body string) error {
-	from := "no-reply@sessioninit-kafff.jota-fab.com" // Replace with your verified no-reply email
+// SESSender sends emails through AWS SES V2.
+type SESSender struct {
+	Client SESV2API
+	From   string
+}
+
+// Send sends an email using AWS SES V2.
+func (s *SESSender) Send(ctx context.Context, to, subject, body string) error {
 	charSet := "UTF-8"
 
 	// Assemble the email.
@@ -46,39 +52,25 @@ func SendEmailAWS(svc SESV2API, to, subject, body string) error {
 				},
 			},
 		},
-		FromEmailAddress: aws.String(from), // Set the FromEmailAddress
+		FromEmailAddress: aws.String(s.From), // Set the FromEmailAddress
 	}
 
 	// Attempt to send the email.
-	result, err := svc.SendEmail(context.TODO(), input)
-
+	result, err := s.Client.SendEmail(ctx, input)
 	if err != nil {
-		log.Println("Error sending email:", err)
-		return err
+		return fmt.Errorf("error sending email with SES: %w", err)
 	}
 
-	fmt.Println("Email sent with message ID:", *result.MessageId)
+	logging.FromContext(ctx).Info("Email sent", "message_id", aws.ToString(result.MessageId))
 	return nil
 }
 
 // NewSESV2Client creates a new SESV2 client using the default AWS configuration.
-func NewSESV2Client() (SESV2API, error) {
-
-
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+func NewSESV2Client(ctx context.Context) (SESV2API, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
 	if err != nil {
-		log.Fatal("Unable to load SDK config, ", err)
-		return nil, err
+		return nil, fmt.Errorf("unable to load AWS SDK config: %w", err)
 	}
 	// Create an SES session.
 	return sesv2.NewFromConfig(cfg), nil
 }
-
-// SendEmail sends an email with the given subject and body to the recipient using AWS SES V2.
-func SendEmail(to, subject, body string) error {
-	svc, err := NewSESV2Client()
-	if err != nil {
-		return err
-	}
-	return SendEmailAWS(svc, to, subject, body)
-}
\ No newline at end of file
@@ -3,48 +3,40 @@ package auth
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/sessions"
-)
-
-// Session Key (Keep this secret!)
-var sessionKey = []byte(os.Getenv("SESSION_KEY")) // Get from environment
-var store *sessions.CookieStore
 
-func init() {
-	if len(sessionKey) == 0 {
-		sessionKey = []byte("super-secret-key") // Development fallback
-		log.Println("Warning: Using default session key.  Set SESSION_KEY environment variable in production!")
-	}
-	store = sessions.NewCookieStore(sessionKey)
-	store.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   3600 * 2, // 8 hours
-		HttpOnly: true,
-		Secure:   false, // Set to true in production
-		SameSite: http.SameSiteLaxMode,
-	}
-}
+	"kaffino/internal/logging"
+)
 
 type contextKey string
 
 const userContextKey contextKey = "user"
 
+const guestPrefix = "schrödinger-"
+
 // GenerateGuestUserID generates a unique ID for guest users.
 func GenerateGuestUserID() string {
-	return "schrödinger-" + uuid.New().String()
+	return guestPrefix + uuid.New().String()
+}
+
+// IsGuest reports whether the user ID belongs to an anonymous guest session.
+func IsGuest(userID string) bool {
+	return strings.HasPrefix(userID, guestPrefix)
+}
+
+// UserIDFromContext returns the user ID stored by SessionMiddleware.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value("userID").(string)
+	return userID, ok && userID != ""
 }
 
 // SessionMiddleware is middleware that checks for a session cookie and retrieves the user information.
-func SessionMiddleware(next http.Handler) http.Handler {
+func (h *Handlers) SessionMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, err := store.Get(r, "session-name") // Get session, create if doesn't exist
-		log.Println("Session:", session)
+		session, err := h.store.Get(r, "session-name") // Get session, create if doesn't exist
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -66,24 +58,12 @@ func SessionMiddleware(next http.Handler) http.Handler {
 		}
 		username := session.Values["username"]
 
-		var disallowedGuestRoutes = []string{
-			"/create-product",
-			"/profile",
-			"/update-product", // Add this route
-		}
-
-		if strings.HasPrefix(userID.(string), "schrödinger-") {
-			for _, route := range disallowedGuestRoutes {
-				if r.URL.Path == route {
-					// Redirect guest users to the login page or an unauthorized page
-					http.Redirect(w, r, "/login", http.StatusSeeOther) // Or "/unauthorized"
-					return
-				}
-			}
-		}
+		// Routes that need a logged-in user or a role are guarded by
+		// RequireRole where they are registered.
 
 		// Session is valid, add the user information to the request context
-		ctx := context.WithValue(r.Context(), "userID", userID.(string))
+		ctx := logging.WithUserID(r.Context(), userID.(string))
+		ctx = context.WithValue(ctx, "userID", userID.(string))
 		ctx = context.WithValue(ctx, userContextKey, username.(string))
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -100,8 +80,8 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "<h1>Welcome, %s!</h1>", user) // Display the user's email
 }
 
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	session, err := store.Get(r, "session-name")
+func (h *Handlers) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := h.store.Get(r, "session-name")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -109,7 +89,7 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Clear session values
 	session.Values["userID"] = nil
-	session.Options.MaxAge = -1 // Expire the cookie
+	session.Options.MaxAge = -1 // Delete the session and expire the cookie
 
 	err = session.Save(r, w)
 	if err != nil {
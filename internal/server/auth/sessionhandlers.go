@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+)
+
+type sessionResponse struct {
+	ID         string `json:"id"`
+	Device     string `json:"device"`
+	IPAddress  string `json:"ip_address"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	ExpiresAt  string `json:"expires_at"`
+	Current    bool   `json:"current"`
+}
+
+// SweepSessions periodically removes expired sessions.
+func (h *Handlers) SweepSessions(interval time.Duration) {
+	h.store.SweepExpired(interval)
+}
+
+// ListSessionsHandler lists the active sessions of the logged-in user.
+func (h *Handlers) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || IsGuest(userID) {
+		jsonResponse(w, http.StatusUnauthorized, response{Success: false, Error: "Login required"})
+		return
+	}
+	current, err := h.store.Get(r, "session-name")
+	if err != nil {
+		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: err.Error()})
+		return
+	}
+
+	sessions, err := h.sessions.ListUserSessions(r.Context(), userID, time.Now())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error listing sessions", "error", err)
+		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: "Failed to list sessions"})
+		return
+	}
+
+	resp := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, sessionResponse{
+			ID:         s.ID,
+			Device:     s.UserAgent,
+			IPAddress:  s.IpAddress,
+			CreatedAt:  s.CreatedAt.Format(time.RFC3339),
+			LastSeenAt: s.LastSeenAt.Format(time.RFC3339),
+			ExpiresAt:  s.ExpiresAt.Format(time.RFC3339),
+			Current:    s.ID == current.ID,
+		})
+	}
+	jsonResponse(w, http.StatusOK, response{Success: true, Data: resp})
+}
+
+// RevokeSessionHandler logs out one of the sessions of the logged-in user.
+func (h *Handlers) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || IsGuest(userID) {
+		jsonResponse(w, http.StatusUnauthorized, response{Success: false, Error: "Login required"})
+		return
+	}
+
+	if err := h.sessions.DeleteUserSession(r.Context(), userID, r.PathValue("id")); err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			jsonResponse(w, http.StatusNotFound, response{Success: false, Error: "Session not found"})
+			return
+		}
+		logging.FromContext(r.Context()).Error("Error revoking session", "error", err)
+		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: "Failed to revoke session"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeUserSessionsHandler logs a user out everywhere. It is meant for
+// admins handling a compromised account.
+func (h *Handlers) RevokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	n, err := h.sessions.DeleteUserSessions(r.Context(), userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Error revoking sessions", "error", err)
+		jsonResponse(w, http.StatusInternalServerError, response{Success: false, Error: "Failed to revoke sessions"})
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("Revoked user sessions", "target_user_id", userID, "count", n)
+	jsonResponse(w, http.StatusOK, response{Success: true, Message: "Sessions revoked", Data: map[string]int64{"revoked": n}})
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+)
+
+// SessionStore keeps the server-side sessions. database.Service implements
+// it on SQLite.
+type SessionStore interface {
+	GetSession(ctx context.Context, id string, now time.Time) (*database.Session, error)
+	SaveSession(ctx context.Context, session *database.Session) error
+	TouchSession(ctx context.Context, id string, now, notSeenSince time.Time) error
+	DeleteSession(ctx context.Context, id string) error
+	ListUserSessions(ctx context.Context, userID string, now time.Time) ([]database.Session, error)
+	DeleteUserSession(ctx context.Context, userID, id string) error
+	DeleteUserSessions(ctx context.Context, userID string) (int64, error)
+	DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error)
+}
+
+// touchInterval is how often the last seen time of a session is updated.
+const touchInterval = time.Minute
+
+// DBStore is a sessions.Store that keeps session values in a SessionStore.
+// The cookie only holds the signed session ID, so deleting a session on the
+// server logs that browser out.
+type DBStore struct {
+	db      SessionStore
+	codecs  []securecookie.Codec
+	Options *sessions.Options
+	// TrustedProxies are the peers whose X-Real-IP header names the client.
+	TrustedProxies []netip.Prefix
+}
+
+// NewDBStore creates a session store that signs its cookies with keyPairs.
+func NewDBStore(db SessionStore, keyPairs ...[]byte) *DBStore {
+	return &DBStore{
+		db:     db,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   3600 * 2, // 2 hours
+			HttpOnly: true,
+			Secure:   false, // NewHandlers turns it on outside development
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+// Get returns a cached session for the request or loads it.
+func (s *DBStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named in the request cookie. A missing, invalid,
+// expired or revoked session gives a new empty session.
+func (s *DBStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	now := time.Now()
+	stored, err := s.db.GetSession(r.Context(), id, now)
+	if err != nil {
+		if errors.Is(err, database.ErrSessionNotFound) {
+			return session, nil
+		}
+		return session, err
+	}
+	if err := (securecookie.GobEncoder{}).Deserialize(stored.Data, &session.Values); err != nil {
+		logging.FromContext(r.Context()).Error("Error decoding session", "error", err)
+		return session, nil
+	}
+	session.ID = id
+	session.IsNew = false
+
+	if err := s.db.TouchSession(r.Context(), id, now, now.Add(-touchInterval)); err != nil {
+		logging.FromContext(r.Context()).Error("Error touching session", "error", err)
+	}
+	return session, nil
+}
+
+// Save stores the session and sets its cookie. A negative MaxAge deletes the
+// session.
+func (s *DBStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.db.DeleteSession(r.Context(), session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		session.ID = id
+	}
+
+	data, err := (securecookie.GobEncoder{}).Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+	var userID sql.NullString
+	if id, ok := session.Values["userID"].(string); ok && !IsGuest(id) {
+		userID = sql.NullString{String: id, Valid: true}
+	}
+
+	now := time.Now()
+	stored := &database.Session{
+		ID:         session.ID,
+		UserID:     userID,
+		Data:       data,
+		UserAgent:  r.UserAgent(),
+		IpAddress:  s.clientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Duration(session.Options.MaxAge) * time.Second),
+	}
+	if err := s.db.SaveSession(r.Context(), stored); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// renew deletes the stored session and clears its ID, so the next Save
+// stores it under a new ID. It is called on login so a session ID planted
+// before login is worthless afterwards.
+func (s *DBStore) renew(r *http.Request, session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+	if err := s.db.DeleteSession(r.Context(), session.ID); err != nil {
+		return err
+	}
+	session.ID = ""
+	return nil
+}
+
+// SweepExpired periodically removes expired sessions.
+func (s *DBStore) SweepExpired(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := s.db.DeleteExpiredSessions(context.Background(), time.Now())
+		if err != nil {
+			slog.Error("Failed to delete expired sessions", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("Deleted expired sessions", "count", n)
+		}
+	}
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// clientIP returns the address of the client. Behind the nginx proxy the
+// real address comes in X-Real-IP, which is only believed from a trusted
+// proxy since any client can send it.
+func (s *DBStore) clientIP(r *http.Request) string {
+	peer, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	addr := peer.Addr().Unmap()
+	for _, proxy := range s.TrustedProxies {
+		if !proxy.Contains(addr) {
+			continue
+		}
+		if ip, err := netip.ParseAddr(r.Header.Get("X-Real-IP")); err == nil {
+			return ip.Unmap().String()
+		}
+		break
+	}
+	return addr.String()
+}
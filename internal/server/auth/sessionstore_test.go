@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"kaffino/internal/database"
+)
+
+// memorySessions is an in-memory SessionStore.
+type memorySessions struct {
+	mu       sync.Mutex
+	sessions map[string]database.Session
+}
+
+func (m *memorySessions) GetSession(ctx context.Context, id string, now time.Time) (*database.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || !s.ExpiresAt.After(now) {
+		return nil, database.ErrSessionNotFound
+	}
+	return &s, nil
+}
+
+func (m *memorySessions) SaveSession(ctx context.Context, session *database.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.ID] = *session
+	return nil
+}
+
+func (m *memorySessions) TouchSession(ctx context.Context, id string, now, notSeenSince time.Time) error {
+	return nil
+}
+
+func (m *memorySessions) DeleteSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *memorySessions) ListUserSessions(ctx context.Context, userID string, now time.Time) ([]database.Session, error) {
+	return nil, nil
+}
+
+func (m *memorySessions) DeleteUserSession(ctx context.Context, userID, id string) error {
+	return m.DeleteSession(ctx, id)
+}
+
+func (m *memorySessions) DeleteUserSessions(ctx context.Context, userID string) (int64, error) {
+	return 0, nil
+}
+
+func (m *memorySessions) DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestDBStore(t *testing.T) {
+	db := &memorySessions{sessions: make(map[string]database.Session)}
+	store := NewDBStore(db, []byte("test-key"))
+
+	// Save a session and keep its cookie
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	session, err := store.Get(r, "session-name")
+	if err != nil {
+		t.Fatalf("error getting session. Err: %v", err)
+	}
+	session.Values["userID"] = "user-1"
+	if err := session.Save(r, w); err != nil {
+		t.Fatalf("error saving session. Err: %v", err)
+	}
+	cookie := w.Result().Cookies()[0]
+
+	if stored := db.sessions[session.ID]; stored.UserID.String != "user-1" {
+		t.Errorf("expected stored session of user-1; got %+v", stored)
+	}
+
+	// The cookie brings the session back
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	loaded, err := store.Get(r, "session-name")
+	if err != nil {
+		t.Fatalf("error getting session. Err: %v", err)
+	}
+	if loaded.IsNew || loaded.Values["userID"] != "user-1" {
+		t.Errorf("expected the saved session; got %+v", loaded)
+	}
+
+	// A tampered cookie gives a new session
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value + "x"})
+	if tampered, _ := store.Get(r, "session-name"); !tampered.IsNew {
+		t.Error("expected a tampered cookie to give a new session")
+	}
+
+	// A revoked session is gone even though the browser still has the cookie
+	db.DeleteSession(context.Background(), session.ID)
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	if revoked, _ := store.Get(r, "session-name"); !revoked.IsNew || revoked.Values["userID"] != nil {
+		t.Errorf("expected a revoked session to give a new session; got %+v", revoked)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	store := NewDBStore(&memorySessions{}, []byte("test-key"))
+	store.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("172.18.0.0/16")}
+
+	tests := []struct {
+		remoteAddr, realIP, want string
+	}{
+		{"203.0.113.7:5123", "", "203.0.113.7"},
+		{"203.0.113.7:5123", "198.51.100.1", "203.0.113.7"},
+		{"172.18.0.5:4000", "198.51.100.1", "198.51.100.1"},
+		{"172.18.0.5:4000", "", "172.18.0.5"},
+		{"172.18.0.5:4000", "not an address", "172.18.0.5"},
+		{"[::ffff:172.18.0.5]:4000", "198.51.100.1", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := store.clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%s, X-Real-IP %q) = %s; want %s", tt.remoteAddr, tt.realIP, got, tt.want)
+		}
+	}
+}
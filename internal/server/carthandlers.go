@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+	"kaffino/internal/server/auth"
+)
+
+type cartItemResponse struct {
+	ID        string  `json:"id"`
+	ProductID string  `json:"product_id"`
+	Size      string  `json:"size"`
+	Schedule  string  `json:"schedule"`
+	Quantity  int64   `json:"quantity"`
+	Price     float64 `json:"price"`
+}
+
+type cartResponse struct {
+	ID    string             `json:"id,omitempty"`
+	Items []cartItemResponse `json:"items"`
+	Total float64            `json:"total"`
+}
+
+type cartQuantityRequest struct {
+	Quantity int64 `json:"quantity"`
+}
+
+func newCartResponse(cart *database.CartDetails) cartResponse {
+	resp := cartResponse{
+		ID:    cart.ID,
+		Items: make([]cartItemResponse, 0, len(cart.Items)),
+	}
+	for _, item := range cart.Items {
+		resp.Items = append(resp.Items, cartItemResponse{
+			ID:        item.ID,
+			ProductID: item.ProductID,
+			Size:      item.Size,
+			Schedule:  item.Schedule,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+		})
+		resp.Total += item.Price * float64(item.Quantity)
+	}
+	resp.Total = math.Round(resp.Total*100) / 100
+	return resp
+}
+
+func (s *Server) getCartHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Missing session", http.StatusUnauthorized)
+		return
+	}
+
+	cart, err := s.db.GetCart(r.Context(), userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to get cart", "error", err)
+		http.Error(w, "Failed to get cart", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newCartResponse(cart))
+}
+
+func (s *Server) addCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Missing session", http.StatusUnauthorized)
+		return
+	}
+
+	var req orderItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	cart, err := s.db.AddCartItem(r.Context(), userID, database.CartItemInput{
+		ProductID: req.ProductID,
+		Size:      req.Size,
+		Schedule:  req.Schedule,
+		Quantity:  req.Quantity,
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCartItem) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to add cart item", "error", err)
+		http.Error(w, "Failed to add cart item", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newCartResponse(cart))
+}
+
+func (s *Server) updateCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Missing session", http.StatusUnauthorized)
+		return
+	}
+
+	var req cartQuantityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	cart, err := s.db.UpdateCartItem(r.Context(), userID, r.PathValue("itemId"), req.Quantity)
+	if err != nil {
+		if errors.Is(err, database.ErrCartItemNotFound) {
+			http.Error(w, "Cart item not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to update cart item", "error", err)
+		http.Error(w, "Failed to update cart item", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newCartResponse(cart))
+}
+
+func (s *Server) removeCartItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Missing session", http.StatusUnauthorized)
+		return
+	}
+
+	cart, err := s.db.RemoveCartItem(r.Context(), userID, r.PathValue("itemId"))
+	if err != nil {
+		if errors.Is(err, database.ErrCartItemNotFound) {
+			http.Error(w, "Cart item not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to remove cart item", "error", err)
+		http.Error(w, "Failed to remove cart item", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newCartResponse(cart))
+}
+
+func (s *Server) clearCartHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Missing session", http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.db.ClearCart(r.Context(), userID); err != nil {
+		logging.FromContext(r.Context()).Error("Failed to clear cart", "error", err)
+		http.Error(w, "Failed to clear cart", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+)
+
+type discountRequest struct {
+	Code           string     `json:"code"`
+	Description    string     `json:"description"`
+	Kind           string     `json:"kind"`
+	Value          float64    `json:"value"`
+	MinOrderAmount float64    `json:"min_order_amount"`
+	StartsAt       *time.Time `json:"starts_at"`
+	EndsAt         *time.Time `json:"ends_at"`
+	MaxUses        int64      `json:"max_uses"`
+	MaxUsesPerUser int64      `json:"max_uses_per_user"`
+	ProductIDs     []string   `json:"product_ids"`
+	Tags           []string   `json:"tags"`
+	// Active defaults to true, so a new code works right away.
+	Active *bool `json:"active"`
+}
+
+func (req *discountRequest) toInput() (database.DiscountInput, error) {
+	kind, err := database.ParseDiscountKind(req.Kind)
+	if err != nil {
+		return database.DiscountInput{}, err
+	}
+	input := database.DiscountInput{
+		Code:           req.Code,
+		Description:    req.Description,
+		Kind:           kind,
+		Value:          req.Value,
+		MinOrderAmount: req.MinOrderAmount,
+		MaxUses:        req.MaxUses,
+		MaxUsesPerUser: req.MaxUsesPerUser,
+		ProductIDs:     req.ProductIDs,
+		Tags:           req.Tags,
+		Active:         req.Active == nil || *req.Active,
+	}
+	if req.StartsAt != nil {
+		input.StartsAt = *req.StartsAt
+	}
+	if req.EndsAt != nil {
+		input.EndsAt = *req.EndsAt
+	}
+	return input, nil
+}
+
+type discountResponse struct {
+	ID             string     `json:"id"`
+	Code           string     `json:"code"`
+	Description    string     `json:"description,omitempty"`
+	Kind           string     `json:"kind"`
+	Value          float64    `json:"value"`
+	MinOrderAmount float64    `json:"min_order_amount"`
+	StartsAt       *time.Time `json:"starts_at,omitempty"`
+	EndsAt         *time.Time `json:"ends_at,omitempty"`
+	MaxUses        int64      `json:"max_uses,omitempty"`
+	MaxUsesPerUser int64      `json:"max_uses_per_user,omitempty"`
+	ProductIDs     []string   `json:"product_ids"`
+	Tags           []string   `json:"tags"`
+	Active         bool       `json:"active"`
+	Uses           int64      `json:"uses"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func newDiscountResponse(d *database.DiscountDetails) discountResponse {
+	resp := discountResponse{
+		ID:             d.ID,
+		Code:           d.Code,
+		Description:    d.Description.String,
+		Kind:           d.Kind,
+		Value:          d.Value,
+		MinOrderAmount: d.MinOrderAmount,
+		MaxUses:        d.MaxUses.Int64,
+		MaxUsesPerUser: d.MaxUsesPerUser.Int64,
+		ProductIDs:     d.ProductIDs,
+		Tags:           d.Tags,
+		Active:         d.Active,
+		Uses:           d.Uses,
+		CreatedAt:      d.CreatedAt.Time,
+	}
+	if d.StartsAt.Valid {
+		resp.StartsAt = &d.StartsAt.Time
+	}
+	if d.EndsAt.Valid {
+		resp.EndsAt = &d.EndsAt.Time
+	}
+	if resp.ProductIDs == nil {
+		resp.ProductIDs = []string{}
+	}
+	if resp.Tags == nil {
+		resp.Tags = []string{}
+	}
+	return resp
+}
+
+func (s *Server) createDiscountHandler(w http.ResponseWriter, r *http.Request) {
+	var req discountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	input, err := req.toInput()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	discount, err := s.db.CreateDiscount(r.Context(), input)
+	if err != nil {
+		writeDiscountError(w, r, "create", err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newDiscountResponse(discount))
+}
+
+// updateDiscountHandler replaces a discount code. Marketing ends a promotion
+// early by sending it with "active": false.
+func (s *Server) updateDiscountHandler(w http.ResponseWriter, r *http.Request) {
+	var req discountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+	input, err := req.toInput()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	discount, err := s.db.UpdateDiscount(r.Context(), r.PathValue("id"), input)
+	if err != nil {
+		writeDiscountError(w, r, "update", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newDiscountResponse(discount))
+}
+
+func (s *Server) getDiscountHandler(w http.ResponseWriter, r *http.Request) {
+	discount, err := s.db.GetDiscount(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeDiscountError(w, r, "get", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newDiscountResponse(discount))
+}
+
+func (s *Server) listDiscountsHandler(w http.ResponseWriter, r *http.Request) {
+	discounts, err := s.db.ListDiscounts(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to list discounts", "error", err)
+		http.Error(w, "Failed to list discounts", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]discountResponse, 0, len(discounts))
+	for i := range discounts {
+		resp = append(resp, newDiscountResponse(&discounts[i]))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeDiscountError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	switch {
+	case errors.Is(err, database.ErrDiscountNotFound):
+		http.Error(w, "Discount not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrInvalidDiscount):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrDiscountExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		logging.FromContext(r.Context()).Error("Failed to "+action+" discount", "error", err)
+		http.Error(w, "Failed to "+action+" discount", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"time"
+
+	"kaffino/internal/logging"
+	"kaffino/internal/server/auth"
+)
+
+// readyTimeout bounds the checks of the readiness probe.
+const readyTimeout = 2 * time.Second
+
+type probeResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// livezHandler reports that the process is up and serving. It checks
+// nothing else, so a failing dependency never gets the process restarted.
+func (s *Server) livezHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, probeResponse{Status: "ok"})
+}
+
+// readyzHandler reports whether the server can take traffic: the database
+// is reachable with its schema in place and an email backend is set up to
+// deliver. It answers 503 while any check fails.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	resp := probeResponse{Status: "ok", Checks: map[string]string{"database": "ok", "email": "ok"}}
+	if err := s.db.Ready(ctx); err != nil {
+		logging.FromContext(r.Context()).Warn("Database not ready", "error", err)
+		resp.Checks["database"] = err.Error()
+		resp.Status = "unavailable"
+	}
+	if err := auth.CheckEmailSender(s.mailer); err != nil {
+		resp.Checks["email"] = err.Error()
+		resp.Status = "unavailable"
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
+
+type diagnosticsResponse struct {
+	Database   map[string]string `json:"database"`
+	Uptime     string            `json:"uptime"`
+	GoVersion  string            `json:"go_version"`
+	Goroutines int               `json:"goroutines"`
+}
+
+// diagnosticsHandler shows database pool stats and runtime details to
+// admins.
+func (s *Server) diagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, diagnosticsResponse{
+		Database:   s.db.Health(),
+		Uptime:     time.Since(s.startedAt).Round(time.Second).String(),
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+	})
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kaffino/internal/database"
+	"kaffino/internal/server/auth"
+)
+
+// readyDB is a database that only answers readiness checks.
+type readyDB struct {
+	database.Service
+	err error
+}
+
+func (db *readyDB) Ready(ctx context.Context) error {
+	return db.err
+}
+
+func TestProbes(t *testing.T) {
+	db := &readyDB{}
+	s := &Server{db: db, mailer: &auth.DevSender{Out: io.Discard, From: "hola@kaffino.pe"}}
+
+	rec := httptest.NewRecorder()
+	s.livezHandler(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected live; got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected ready; got %d %s", rec.Code, rec.Body)
+	}
+
+	// A database outage makes the server unready but still live
+	db.err = errors.New("database is locked")
+	rec = httptest.NewRecorder()
+	s.readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "database is locked") {
+		t.Errorf("expected unready with the database error; got %d %s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	s.livezHandler(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected live during a database outage; got %d", rec.Code)
+	}
+
+	db.err = nil
+	for _, mailer := range []auth.EmailSender{nil, &auth.SMTPSender{From: "hola@kaffino.pe"}} {
+		s.mailer = mailer
+		rec = httptest.NewRecorder()
+		s.readyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"email":"no`) {
+			t.Errorf("expected unready without a usable email backend; got %d %s", rec.Code, rec.Body)
+		}
+	}
+}
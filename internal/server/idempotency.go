@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+	"kaffino/internal/server/auth"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyKeyTTL is how long responses are kept for retries.
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyLockTimeout is how long a request may run before its key
+	// is assumed abandoned, say by a restart, and can be taken over. It is
+	// longer than the server's write timeout.
+	idempotencyLockTimeout = time.Minute
+	maxIdempotencyKeyLen   = 255
+	maxIdempotentBody      = 1 << 20
+)
+
+// idempotent makes a POST handler safe to retry. When the request carries
+// an Idempotency-Key header, the first response for that key and user is
+// stored and sent again to retries instead of running the handler twice.
+// Reusing a key for a different request gets a 422, and retrying while the
+// first request is still running a 409. Server errors are not stored, so
+// the request can be retried with the same key.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+		userID, ok := auth.UserIDFromContext(r.Context())
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := time.Now()
+		hash := requestHash(r, body)
+		stored, reserved, err := s.db.ReserveIdempotencyKey(r.Context(), &database.IdempotencyKey{
+			UserID:      userID,
+			Key:         key,
+			RequestHash: hash,
+			CreatedAt:   now,
+		}, now.Add(-idempotencyLockTimeout))
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to reserve idempotency key", "error", err)
+			http.Error(w, "Failed to process request", http.StatusInternalServerError)
+			return
+		}
+
+		if !reserved {
+			switch {
+			case stored.RequestHash != hash:
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			case !stored.StatusCode.Valid:
+				http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+			default:
+				if stored.ContentType != "" {
+					w.Header().Set("Content-Type", stored.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(int(stored.StatusCode.Int64))
+				if _, err := w.Write(stored.ResponseBody); err != nil {
+					logging.FromContext(r.Context()).Error("Failed to write response", "error", err)
+				}
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		// Store the outcome even if the client has gone, that is when it
+		// retries
+		ctx := context.WithoutCancel(r.Context())
+		if rec.status >= 500 {
+			err = s.db.ReleaseIdempotencyKey(ctx, userID, key)
+		} else {
+			err = s.db.SaveIdempotentResponse(ctx, userID, key, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes())
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+// requestHash identifies a request by its method, path and body.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// sweepIdempotencyKeys periodically deletes the keys older than
+// idempotencyKeyTTL.
+func (s *Server) sweepIdempotencyKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := s.db.DeleteExpiredIdempotencyKeys(context.Background(), time.Now().Add(-idempotencyKeyTTL))
+		if err != nil {
+			slog.Error("Failed to delete expired idempotency keys", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("Deleted expired idempotency keys", "count", n)
+		}
+	}
+}
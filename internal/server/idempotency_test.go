@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kaffino/internal/database"
+)
+
+// keysDB keeps idempotency keys in memory.
+type keysDB struct {
+	database.Service
+	keys map[string]*database.IdempotencyKey
+}
+
+func (db *keysDB) ReserveIdempotencyKey(ctx context.Context, key *database.IdempotencyKey, staleBefore time.Time) (*database.IdempotencyKey, bool, error) {
+	stored, ok := db.keys[key.UserID+"/"+key.Key]
+	if ok && (stored.StatusCode.Valid || !stored.CreatedAt.Before(staleBefore)) {
+		return stored, false, nil
+	}
+	copied := *key
+	db.keys[key.UserID+"/"+key.Key] = &copied
+	return nil, true, nil
+}
+
+func (db *keysDB) SaveIdempotentResponse(ctx context.Context, userID, key string, statusCode int, contentType string, body []byte) error {
+	stored := db.keys[userID+"/"+key]
+	stored.StatusCode = sql.NullInt64{Int64: int64(statusCode), Valid: true}
+	stored.ContentType = contentType
+	stored.ResponseBody = body
+	return nil
+}
+
+func (db *keysDB) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	delete(db.keys, userID+"/"+key)
+	return nil
+}
+
+func TestIdempotent(t *testing.T) {
+	db := &keysDB{keys: map[string]*database.IdempotencyKey{}}
+	s := &Server{db: db}
+
+	calls := 0
+	status := http.StatusCreated
+	handler := s.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeJSON(w, status, map[string]int{"order": calls})
+	})
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(body))
+		req.Header.Set(idempotencyKeyHeader, key)
+		req = req.WithContext(context.WithValue(req.Context(), "userID", "u1"))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	first := post("k1", `{"items":[]}`)
+	retry := post("k1", `{"items":[]}`)
+	if calls != 1 {
+		t.Errorf("expected the handler to run once; ran %d times", calls)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected the original response; got %d %s", retry.Code, retry.Body)
+	}
+	if ct := retry.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected the original content type; got %q", ct)
+	}
+
+	if rec := post("k1", `{"items":[1]}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a different body to be rejected; got %d", rec.Code)
+	}
+
+	// A request still running blocks retries until it is abandoned
+	post("k2", `{}`)
+	db.keys["u1/k2"].StatusCode = sql.NullInt64{}
+	if rec := post("k2", `{}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected a retry during the request to be rejected; got %d", rec.Code)
+	}
+	db.keys["u1/k2"].CreatedAt = time.Now().Add(-2 * idempotencyLockTimeout)
+	if rec := post("k2", `{}`); rec.Code != http.StatusCreated {
+		t.Errorf("expected an abandoned key to be taken over; got %d", rec.Code)
+	}
+
+	// Server errors can be retried with the same key
+	status = http.StatusInternalServerError
+	post("k3", `{}`)
+	status = http.StatusCreated
+	if rec := post("k3", `{}`); rec.Code != http.StatusCreated {
+		t.Errorf("expected a retry after a server error to run; got %d", rec.Code)
+	}
+
+	calls = 0
+	post("", `{}`)
+	post("", `{}`)
+	if calls != 2 {
+		t.Errorf("expected requests without a key to always run; ran %d times", calls)
+	}
+}
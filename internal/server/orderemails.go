@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"kaffino/internal/database"
+)
+
+// sendOrderConfirmation emails the customer the itemized totals of an order
+// they placed. The order is already committed, so a failure is only logged.
+func (s *Server) sendOrderConfirmation(ctx context.Context, email string, order *database.OrderDetails) {
+	titles := make(map[string]string, len(order.Items))
+	for _, item := range order.Items {
+		if _, ok := titles[item.ProductID]; ok {
+			continue
+		}
+		product, err := s.db.GetProduct(ctx, item.ProductID)
+		if err != nil {
+			slog.Error("Failed to get product for order email", "order_id", order.ID, "product_id", item.ProductID, "error", err)
+			titles[item.ProductID] = item.ProductID
+			continue
+		}
+		titles[item.ProductID] = product.Title
+	}
+
+	subject := "Your Kaffino order " + shortOrderID(order.ID)
+	body := "Thank you for your order.\n\n" + orderSummary(order, titles)
+	if err := s.mailer.Send(ctx, email, subject, body); err != nil {
+		slog.Error("Failed to send order confirmation", "order_id", order.ID, "error", err)
+	}
+}
+
+// orderSummary writes the items and the totals of an order as plain text,
+// with the IGV it includes or adds.
+func orderSummary(order *database.OrderDetails, titles map[string]string) string {
+	var b strings.Builder
+	line := func(label string, amount float64) {
+		fmt.Fprintf(&b, "%-40s %12s\n", label, soles(amount))
+	}
+
+	fmt.Fprintf(&b, "Order %s, placed on %s.\n\n", shortOrderID(order.ID), order.OrderDate.Time.In(limaTime).Format("2 January 2006"))
+	for _, item := range order.Items {
+		label := fmt.Sprintf("%d x %s", item.Quantity, titles[item.ProductID])
+		if item.Size.String != "" {
+			label += ", " + item.Size.String
+		}
+		line(label, item.Price*float64(item.Quantity))
+		if item.DiscountAmount > 0 {
+			line("    discount", -item.DiscountAmount)
+		}
+	}
+	b.WriteString("\n")
+
+	line("Subtotal", order.SubtotalAmount)
+	if order.DiscountAmount > 0 {
+		label := "Discount"
+		if order.DiscountCode.Valid {
+			label += " (" + order.DiscountCode.String + ")"
+		}
+		line(label, -order.DiscountAmount)
+	}
+	line("Shipping", order.ShippingAmount)
+
+	igv := fmt.Sprintf("IGV (%g%%)", order.TaxRate.Float64*100)
+	if !order.TaxRate.Valid || order.PricesIncludeTax {
+		line("Total", order.TotalAmount)
+		if order.TaxRate.Valid {
+			line(igv+" included", order.TaxAmount)
+		}
+	} else {
+		line(igv, order.TaxAmount)
+		line("Total", order.TotalAmount)
+	}
+	return b.String()
+}
+
+// soles formats an amount in Peruvian soles.
+func soles(amount float64) string {
+	if amount < 0 {
+		return fmt.Sprintf("-S/ %.2f", -amount)
+	}
+	return fmt.Sprintf("S/ %.2f", amount)
+}
+
+// shortOrderID is the part of an order ID customers are shown.
+func shortOrderID(id string) string {
+	if len(id) > 8 {
+		return strings.ToUpper(id[:8])
+	}
+	return strings.ToUpper(id)
+}
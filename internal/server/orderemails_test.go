@@ -0,0 +1,57 @@
+package server
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"kaffino/internal/database"
+)
+
+func TestOrderSummary(t *testing.T) {
+	order := &database.OrderDetails{
+		Order: database.Order{
+			ID:               "c84bc592-135a-4d53-a063-b3464f37ac80",
+			OrderDate:        sql.NullTime{Time: time.Date(2024, time.June, 3, 15, 0, 0, 0, time.UTC), Valid: true},
+			SubtotalAmount:   45,
+			DiscountAmount:   4.5,
+			DiscountCode:     sql.NullString{String: "WELCOME10", Valid: true},
+			ShippingAmount:   8,
+			TaxAmount:        7.4,
+			TaxRate:          sql.NullFloat64{Float64: 0.18, Valid: true},
+			PricesIncludeTax: true,
+			TotalAmount:      48.5,
+		},
+		Items: []database.OrderItem{
+			{ProductID: "p1", Quantity: 2, Price: 15, DiscountAmount: 3, Size: sql.NullString{String: "Half Bag (6oz)", Valid: true}},
+			{ProductID: "p2", Quantity: 1, Price: 15, DiscountAmount: 1.5},
+		},
+	}
+	titles := map[string]string{"p1": "Classic Cappuccino", "p2": "French Press"}
+
+	got := orderSummary(order, titles)
+	for _, want := range []string{
+		"Order C84BC592, placed on 3 June 2024.",
+		"2 x Classic Cappuccino, Half Bag (6oz)",
+		"-S/ 3.00",
+		"Discount (WELCOME10)",
+		"-S/ 4.50",
+		"IGV (18%) included",
+		"S/ 7.40",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected the summary to contain %q; got\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "Total") > strings.Index(got, "IGV") {
+		t.Errorf("expected included IGV after the total; got\n%s", got)
+	}
+
+	order.PricesIncludeTax = false
+	order.TotalAmount = 55.9
+	got = orderSummary(order, titles)
+	if strings.Contains(got, "included") || strings.Index(got, "IGV") > strings.Index(got, "Total") {
+		t.Errorf("expected added IGV before the total; got\n%s", got)
+	}
+}
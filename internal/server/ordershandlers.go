@@ -0,0 +1,392 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+	"kaffino/internal/server/auth"
+)
+
+var ordersPlaced = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "kaffino_orders_placed_total",
+	Help: "Orders placed.",
+})
+
+type orderItemRequest struct {
+	ProductID string `json:"productId"`
+	Size      string `json:"size"`
+	Quantity  int64  `json:"quantity"`
+	Schedule  string `json:"schedule"`
+}
+
+type orderRequest struct {
+	ShippingAddress string             `json:"shipping_address"`
+	BillingAddress  string             `json:"billing_address"`
+	PaymentMethod   string             `json:"payment_method"`
+	DiscountCode    string             `json:"discount_code"`
+	Items           []orderItemRequest `json:"items"`
+}
+
+// UnmarshalJSON also accepts a bare array of items, which is what the cart sends.
+func (o *orderRequest) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return json.Unmarshal(trimmed, &o.Items)
+	}
+	type plain orderRequest
+	return json.Unmarshal(data, (*plain)(o))
+}
+
+type orderItemResponse struct {
+	ID        string  `json:"id"`
+	ProductID string  `json:"product_id"`
+	VariantID string  `json:"variant_id,omitempty"`
+	Size      string  `json:"size,omitempty"`
+	Quantity  int64   `json:"quantity"`
+	Price     float64 `json:"price"`
+	Discount  float64 `json:"discount,omitempty"`
+	Tax       float64 `json:"tax"`
+}
+
+type orderStatusChangeResponse struct {
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	ChangedBy string    `json:"changed_by"`
+	Note      string    `json:"note,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type orderResponse struct {
+	ID              string              `json:"id"`
+	UserID          string              `json:"user_id"`
+	OrderDate       time.Time           `json:"order_date"`
+	SubtotalAmount  float64             `json:"subtotal_amount"`
+	DiscountAmount  float64             `json:"discount_amount"`
+	DiscountCode    string              `json:"discount_code,omitempty"`
+	ShippingAmount  float64             `json:"shipping_amount"`
+	TaxAmount       float64             `json:"tax_amount"`
+	TaxRate         float64             `json:"tax_rate,omitempty"`
+	TaxIncluded     bool                `json:"tax_included"`
+	TotalAmount     float64             `json:"total_amount"`
+	ShippingAddress string              `json:"shipping_address,omitempty"`
+	BillingAddress  string              `json:"billing_address,omitempty"`
+	PaymentMethod   string              `json:"payment_method,omitempty"`
+	Status          string              `json:"order_status"`
+	Items           []orderItemResponse `json:"items"`
+
+	History []orderStatusChangeResponse `json:"status_history,omitempty"`
+}
+
+type orderStatusRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+func newOrderResponse(order *database.OrderDetails) orderResponse {
+	resp := orderResponse{
+		ID:              order.ID,
+		UserID:          order.UserID,
+		OrderDate:       order.OrderDate.Time,
+		SubtotalAmount:  order.SubtotalAmount,
+		DiscountAmount:  order.DiscountAmount,
+		DiscountCode:    order.DiscountCode.String,
+		ShippingAmount:  order.ShippingAmount,
+		TaxAmount:       order.TaxAmount,
+		TaxRate:         order.TaxRate.Float64,
+		TaxIncluded:     order.PricesIncludeTax,
+		TotalAmount:     order.TotalAmount,
+		ShippingAddress: order.ShippingAddress.String,
+		BillingAddress:  order.BillingAddress.String,
+		PaymentMethod:   order.PaymentMethod.String,
+		Status:          order.OrderStatus.String,
+		Items:           make([]orderItemResponse, 0, len(order.Items)),
+	}
+	for _, item := range order.Items {
+		resp.Items = append(resp.Items, orderItemResponse{
+			ID:        item.ID,
+			ProductID: item.ProductID,
+			VariantID: item.InventoryID.String,
+			Size:      item.Size.String,
+			Quantity:  item.Quantity,
+			Price:     item.Price,
+			Discount:  item.DiscountAmount,
+			Tax:       item.TaxAmount,
+		})
+	}
+	return resp
+}
+
+func (o *orderResponse) setHistory(history []database.OrderStatusHistory) {
+	for _, h := range history {
+		o.History = append(o.History, orderStatusChangeResponse{
+			From:      h.FromStatus.String,
+			To:        h.ToStatus,
+			ChangedBy: h.ChangedBy,
+			Note:      h.Note.String,
+			ChangedAt: h.CreatedAt.Time,
+		})
+	}
+}
+
+func (s *Server) createOrderHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required to place an order", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse the request body
+	var req orderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	input := database.OrderInput{
+		ShippingAddress: req.ShippingAddress,
+		BillingAddress:  req.BillingAddress,
+		PaymentMethod:   req.PaymentMethod,
+		Charges:         s.charges,
+		DiscountCode:    req.DiscountCode,
+	}
+	for _, item := range req.Items {
+		input.Items = append(input.Items, database.OrderItemInput{
+			ProductID: item.ProductID,
+			Size:      item.Size,
+			Quantity:  item.Quantity,
+		})
+	}
+
+	// Create the order
+	order, err := s.db.CreateOrder(r.Context(), userID, input)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrInvalidOrder):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, database.ErrInsufficientStock):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, database.ErrDiscountNotApplicable):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to create order", "error", err)
+		http.Error(w, "Failed to create order", http.StatusInternalServerError)
+		return
+	}
+
+	ordersPlaced.Inc()
+
+	// The confirmation goes out in the background so a slow mail server
+	// does not hold up checkout
+	go func(ctx context.Context) {
+		email, err := s.db.GetUserEmail(ctx, userID)
+		if err != nil {
+			slog.Error("Failed to get email for order confirmation", "order_id", order.ID, "error", err)
+			return
+		}
+		s.sendOrderConfirmation(ctx, email, order)
+	}(context.WithoutCancel(r.Context()))
+
+	writeJSON(w, http.StatusCreated, newOrderResponse(order))
+}
+
+func (s *Server) getOrderHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	// Get the order ID from the URL
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Missing order ID", http.StatusBadRequest)
+		return
+	}
+
+	order, err := s.db.GetOrder(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, database.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to get order", "error", err)
+		http.Error(w, "Failed to get order", http.StatusInternalServerError)
+		return
+	}
+
+	// Orders of other users are reported as missing rather than forbidden,
+	// except to staff, who fulfil them
+	if order.UserID != userID {
+		staff, err := s.isStaff(r.Context(), userID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to get user role", "error", err)
+			http.Error(w, "Failed to get order", http.StatusInternalServerError)
+			return
+		}
+		if !staff {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	history, err := s.db.GetOrderStatusHistory(r.Context(), order.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to get order history", "error", err)
+		http.Error(w, "Failed to get order", http.StatusInternalServerError)
+		return
+	}
+
+	resp := newOrderResponse(order)
+	resp.setHistory(history)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) updateOrderStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+	// The route is registered behind RequireRole; a request that reached
+	// the handler any other way does not get to move orders.
+	if role, ok := auth.RoleFromContext(r.Context()); !ok || !role.Includes(database.RoleStaff) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Missing order ID", http.StatusBadRequest)
+		return
+	}
+
+	var req orderStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	status, err := database.ParseOrderStatus(req.Status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	order, err := s.db.UpdateOrderStatus(r.Context(), id, status, userID, req.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrOrderNotFound):
+			http.Error(w, "Order not found", http.StatusNotFound)
+		case errors.Is(err, database.ErrInvalidTransition):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			logging.FromContext(r.Context()).Error("Failed to update order status", "error", err)
+			http.Error(w, "Failed to update order status", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	history, err := s.db.GetOrderStatusHistory(r.Context(), order.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to get order history", "error", err)
+		http.Error(w, "Failed to get order history", http.StatusInternalServerError)
+		return
+	}
+
+	resp := newOrderResponse(order)
+	resp.setHistory(history)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) listOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	// Staff list the orders of every user with ?all=true, optionally only
+	// those in one status
+	all := r.URL.Query().Get("all") == "true"
+	var status database.OrderStatus
+	if all {
+		staff, err := s.isStaff(r.Context(), userID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to get user role", "error", err)
+			http.Error(w, "Failed to list orders", http.StatusInternalServerError)
+			return
+		}
+		if !staff {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if q := r.URL.Query().Get("status"); q != "" {
+			if status, err = database.ParseOrderStatus(q); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var (
+		orders []*database.OrderDetails
+		err    error
+	)
+	if all {
+		orders, err = s.db.ListAllOrders(r.Context(), status)
+	} else {
+		orders, err = s.db.ListOrders(r.Context(), userID)
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to list orders", "error", err)
+		http.Error(w, "Failed to list orders", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]orderResponse, 0, len(orders))
+	for _, order := range orders {
+		resp = append(resp, newOrderResponse(order))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// writeJSON marshals v and writes it with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	jsonResp, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(jsonResp); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
+
+// isStaff reports whether a user's role lets them see the orders of every
+// customer.
+func (s *Server) isStaff(ctx context.Context, userID string) (bool, error) {
+	role, err := s.db.GetUserRole(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return role.Includes(database.RoleStaff), nil
+}
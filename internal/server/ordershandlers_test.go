@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"kaffino/internal/database"
+	"kaffino/internal/server/auth"
+)
+
+// statusDB moves a single order between statuses for users with fixed roles.
+type statusDB struct {
+	database.Service
+	roles map[string]database.Role
+	order *database.OrderDetails
+}
+
+func (db *statusDB) GetUserRole(ctx context.Context, userID string) (database.Role, error) {
+	role, ok := db.roles[userID]
+	if !ok {
+		return "", database.ErrUserNotFound
+	}
+	return role, nil
+}
+
+func (db *statusDB) UpdateOrderStatus(ctx context.Context, id string, status database.OrderStatus, changedBy, note string) (*database.OrderDetails, error) {
+	db.order.OrderStatus = sql.NullString{String: string(status), Valid: true}
+	return db.order, nil
+}
+
+func (db *statusDB) GetOrderStatusHistory(ctx context.Context, orderID string) ([]database.OrderStatusHistory, error) {
+	return nil, nil
+}
+
+func TestUpdateOrderStatusRequiresStaff(t *testing.T) {
+	db := &statusDB{
+		roles: map[string]database.Role{"customer": database.RoleCustomer, "staff": database.RoleStaff},
+		order: &database.OrderDetails{Order: database.Order{ID: "o1", OrderStatus: sql.NullString{String: "Pending", Valid: true}}},
+	}
+	s := &Server{db: db}
+	guarded := auth.RequireRole(db, database.RoleStaff)(s.updateOrderStatusHandler)
+
+	patch := func(h http.Handler, userID string) int {
+		req := httptest.NewRequest(http.MethodPatch, "/order/o1/status", strings.NewReader(`{"status":"Paid"}`))
+		req.SetPathValue("id", "o1")
+		req = req.WithContext(context.WithValue(req.Context(), "userID", userID))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := patch(guarded, "customer"); code != http.StatusForbidden {
+		t.Errorf("expected a customer to be refused; got %d", code)
+	}
+	if code := patch(http.HandlerFunc(s.updateOrderStatusHandler), "staff"); code != http.StatusForbidden {
+		t.Errorf("expected the handler to refuse requests that skipped the role check; got %d", code)
+	}
+	if db.order.OrderStatus.String != "Pending" {
+		t.Fatalf("expected the order to stay Pending; got %s", db.order.OrderStatus.String)
+	}
+	if code := patch(guarded, "staff"); code != http.StatusOK {
+		t.Errorf("expected staff to update the order; got %d", code)
+	}
+	if db.order.OrderStatus.String != "Paid" {
+		t.Errorf("expected the order to be Paid; got %s", db.order.OrderStatus.String)
+	}
+}
+
+func (db *statusDB) GetOrder(ctx context.Context, id string) (*database.OrderDetails, error) {
+	if id != db.order.ID {
+		return nil, database.ErrOrderNotFound
+	}
+	return db.order, nil
+}
+
+func (db *statusDB) ListAllOrders(ctx context.Context, status database.OrderStatus) ([]*database.OrderDetails, error) {
+	if status != "" && db.order.OrderStatus.String != string(status) {
+		return nil, nil
+	}
+	return []*database.OrderDetails{db.order}, nil
+}
+
+func TestStaffReadOrdersOfCustomers(t *testing.T) {
+	db := &statusDB{
+		roles: map[string]database.Role{"customer": database.RoleCustomer, "staff": database.RoleStaff, "admin": database.RoleAdmin},
+		order: &database.OrderDetails{Order: database.Order{ID: "o1", UserID: "buyer", OrderStatus: sql.NullString{String: "Paid", Valid: true}}},
+	}
+	s := &Server{db: db}
+
+	get := func(h http.HandlerFunc, target, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.SetPathValue("id", "o1")
+		req = req.WithContext(context.WithValue(req.Context(), "userID", userID))
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	if rec := get(s.getOrderHandler, "/order/o1", "customer"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected another customer's order to be hidden; got %d", rec.Code)
+	}
+	for _, userID := range []string{"staff", "admin"} {
+		if rec := get(s.getOrderHandler, "/order/o1", userID); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":"o1"`) {
+			t.Errorf("expected %s to read the order; got %d %s", userID, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := get(s.listOrdersHandler, "/orders?all=true", "customer"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected a customer to be refused every order; got %d", rec.Code)
+	}
+	if rec := get(s.listOrdersHandler, "/orders?all=true&status=Paid", "staff"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":"o1"`) {
+		t.Errorf("expected staff to list the paid order; got %d %s", rec.Code, rec.Body)
+	}
+	if rec := get(s.listOrdersHandler, "/orders?all=true&status=Shipped", "staff"); rec.Code != http.StatusOK || rec.Body.String() != "[]" {
+		t.Errorf("expected no shipped orders; got %d %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,344 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+	"kaffino/internal/payments"
+	"kaffino/internal/server/auth"
+)
+
+// maxWebhookBody bounds the size of a payment webhook.
+const maxWebhookBody = 1 << 20
+
+var (
+	paymentWebhooks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kaffino_payment_webhooks_total",
+		Help: "Payment webhooks received, by result.",
+	}, []string{"result"})
+	orderRevenue = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kaffino_order_revenue_total",
+		Help: "Total amount of the orders paid, in soles.",
+	})
+	orderRefunds = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kaffino_order_refunds_total",
+		Help: "Total amount of the orders refunded, in soles.",
+	})
+)
+
+type paymentResponse struct {
+	ID           string `json:"id"`
+	OrderID      string `json:"order_id"`
+	Provider     string `json:"provider"`
+	IntentID     string `json:"intent_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+	Status       string `json:"status"`
+}
+
+func newPaymentResponse(payment *database.Payment) paymentResponse {
+	return paymentResponse{
+		ID:       payment.ID,
+		OrderID:  payment.OrderID,
+		Provider: payment.Provider,
+		IntentID: payment.IntentID,
+		Amount:   payment.Amount,
+		Currency: payment.Currency,
+		Status:   payment.Status,
+	}
+}
+
+// createPaymentHandler starts the payment of one of the user's pending
+// orders. While a payment is waiting to be captured it is returned again
+// instead of starting another, unless the provider no longer knows its
+// intent.
+func (s *Server) createPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	order, err := s.db.GetOrder(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, database.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to get order", "error", err)
+		http.Error(w, "Failed to create payment", http.StatusInternalServerError)
+		return
+	}
+	if order.UserID != userID {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if order.OrderStatus.String != string(database.OrderPending) {
+		http.Error(w, "Order is not awaiting payment", http.StatusConflict)
+		return
+	}
+
+	existing, err := s.db.GetOrderPayment(r.Context(), order.ID)
+	switch {
+	case err == nil && existing.Status == string(payments.IntentRequiresCapture):
+		live, err := s.livePayment(r.Context(), existing)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("Failed to check payment", "error", err)
+			http.Error(w, "Failed to create payment", http.StatusBadGateway)
+			return
+		}
+		if live {
+			resp := newPaymentResponse(existing)
+			resp.ClientSecret = existing.ClientSecret
+			writeJSON(w, http.StatusOK, resp)
+			return
+		}
+	case err != nil && !errors.Is(err, database.ErrPaymentNotFound):
+		logging.FromContext(r.Context()).Error("Failed to get payment", "error", err)
+		http.Error(w, "Failed to create payment", http.StatusInternalServerError)
+		return
+	}
+
+	payment, err := s.startPayment(r.Context(), order)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to create payment", "error", err)
+		http.Error(w, "Failed to create payment", http.StatusBadGateway)
+		return
+	}
+
+	resp := newPaymentResponse(payment)
+	resp.ClientSecret = payment.ClientSecret
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// startPayment creates a payment intent for the total of an order and
+// stores it.
+func (s *Server) startPayment(ctx context.Context, order *database.OrderDetails) (*database.Payment, error) {
+	intent, err := s.payments.CreateIntent(ctx, payments.IntentRequest{
+		OrderID:  order.ID,
+		Amount:   payments.Cents(order.TotalAmount),
+		Currency: payments.CurrencyPEN,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating payment intent: %w", err)
+	}
+
+	payment := &database.Payment{
+		OrderID:      order.ID,
+		Provider:     s.payments.Name(),
+		IntentID:     intent.ID,
+		ClientSecret: intent.ClientSecret,
+		Amount:       intent.Amount,
+		Currency:     intent.Currency,
+		Status:       string(intent.Status),
+	}
+	if err := s.db.CreatePayment(ctx, payment); err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// livePayment reports whether the provider still knows the intent of a
+// stored payment. A payment whose intent is gone is marked expired, so a new
+// one can be started.
+func (s *Server) livePayment(ctx context.Context, payment *database.Payment) (bool, error) {
+	_, err := s.payments.GetIntent(ctx, payment.IntentID)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, payments.ErrIntentNotFound) {
+		return false, fmt.Errorf("error getting payment intent: %w", err)
+	}
+	return false, s.expirePayment(ctx, payment)
+}
+
+// expirePayment marks a payment whose intent the provider no longer knows.
+func (s *Server) expirePayment(ctx context.Context, payment *database.Payment) error {
+	logging.FromContext(ctx).Warn("Payment intent unknown to the provider, expiring payment",
+		"payment_id", payment.ID, "intent_id", payment.IntentID)
+	payment.Status = string(payments.IntentExpired)
+	return s.db.SetPaymentStatus(ctx, payment.ID, payment.Status)
+}
+
+// capturePaymentHandler collects the payment of a pending order. The order
+// becomes Paid when the provider's webhook confirms the capture.
+func (s *Server) capturePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	order, payment, ok := s.orderPayment(w, r)
+	if !ok {
+		return
+	}
+	if order.OrderStatus.String != string(database.OrderPending) {
+		http.Error(w, "Order is not awaiting payment", http.StatusConflict)
+		return
+	}
+
+	if payment.Status == string(payments.IntentExpired) {
+		http.Error(w, "Payment expired, it must be started again", http.StatusConflict)
+		return
+	}
+
+	intent, err := s.payments.Capture(r.Context(), payment.IntentID)
+	if errors.Is(err, payments.ErrIntentNotFound) {
+		if err := s.expirePayment(r.Context(), payment); err != nil {
+			logging.FromContext(r.Context()).Error("Failed to expire payment", "error", err)
+		}
+		http.Error(w, "Payment expired, it must be started again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.writeProviderError(w, r, "capture", err)
+		return
+	}
+
+	resp := newPaymentResponse(payment)
+	resp.Status = string(intent.Status)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// refundPaymentHandler gives back the payment of an order. The order
+// becomes Refunded when the provider's webhook confirms the refund.
+func (s *Server) refundPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	order, payment, ok := s.orderPayment(w, r)
+	if !ok {
+		return
+	}
+	if !database.CanTransition(database.OrderStatus(order.OrderStatus.String), database.OrderRefunded) {
+		http.Error(w, "Order cannot be refunded while "+order.OrderStatus.String, http.StatusConflict)
+		return
+	}
+
+	intent, err := s.payments.Refund(r.Context(), payment.IntentID)
+	if err != nil {
+		s.writeProviderError(w, r, "refund", err)
+		return
+	}
+
+	resp := newPaymentResponse(payment)
+	resp.Status = string(intent.Status)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// orderPayment loads the order named in the path and its latest payment,
+// writing the error response when either is missing.
+func (s *Server) orderPayment(w http.ResponseWriter, r *http.Request) (*database.OrderDetails, *database.Payment, bool) {
+	order, err := s.db.GetOrder(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, database.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return nil, nil, false
+		}
+		logging.FromContext(r.Context()).Error("Failed to get order", "error", err)
+		http.Error(w, "Failed to get order", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	payment, err := s.db.GetOrderPayment(r.Context(), order.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrPaymentNotFound) {
+			http.Error(w, "Order has no payment", http.StatusNotFound)
+			return nil, nil, false
+		}
+		logging.FromContext(r.Context()).Error("Failed to get payment", "error", err)
+		http.Error(w, "Failed to get payment", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return order, payment, true
+}
+
+func (s *Server) writeProviderError(w http.ResponseWriter, r *http.Request, action string, err error) {
+	switch {
+	case errors.Is(err, payments.ErrIntentNotFound):
+		http.Error(w, "Payment not found at the provider", http.StatusNotFound)
+	case errors.Is(err, payments.ErrInvalidIntentState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		logging.FromContext(r.Context()).Error("Failed to "+action+" payment", "error", err)
+		http.Error(w, "Failed to "+action+" payment", http.StatusBadGateway)
+	}
+}
+
+// paymentWebhookHandler receives the signed events of the payment provider
+// and applies them to payments and orders. Providers redeliver events until
+// they get a 2xx answer, so each event is applied once and repeats are
+// acknowledged without effect.
+func (s *Server) paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		paymentWebhooks.WithLabelValues("rejected").Inc()
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	event, err := payments.ParseEvent(s.webhookSecret, r.Header.Get(payments.SignatureHeader), body, time.Now())
+	if err != nil {
+		paymentWebhooks.WithLabelValues("rejected").Inc()
+		if errors.Is(err, payments.ErrInvalidSignature) || errors.Is(err, payments.ErrSignatureExpired) {
+			logging.FromContext(r.Context()).Warn("Rejected payment webhook", "error", err)
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	input := database.PaymentEventInput{
+		Provider: s.payments.Name(),
+		EventID:  event.ID,
+		Type:     string(event.Type),
+		IntentID: event.IntentID,
+	}
+	switch event.Type {
+	case payments.EventPaymentSucceeded:
+		input.Status, input.OrderStatus = string(payments.IntentSucceeded), database.OrderPaid
+	case payments.EventPaymentFailed:
+		input.Status = string(payments.IntentFailed)
+	case payments.EventPaymentRefunded:
+		input.Status, input.OrderStatus = string(payments.IntentRefunded), database.OrderRefunded
+	default:
+		paymentWebhooks.WithLabelValues("ignored").Inc()
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+
+	order, err := s.db.ApplyPaymentEvent(r.Context(), input)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrDuplicatePaymentEvent):
+			paymentWebhooks.WithLabelValues("duplicate").Inc()
+			writeJSON(w, http.StatusOK, map[string]string{"status": "duplicate"})
+		case errors.Is(err, database.ErrPaymentNotFound):
+			paymentWebhooks.WithLabelValues("rejected").Inc()
+			http.Error(w, "Payment not found", http.StatusNotFound)
+		default:
+			logging.FromContext(r.Context()).Error("Failed to apply payment event", "event", event.ID, "error", err)
+			http.Error(w, "Failed to apply payment event", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// A payment arriving after its order was cancelled needs a refund by hand
+	if input.OrderStatus != "" && order.OrderStatus.String != string(input.OrderStatus) {
+		logging.FromContext(r.Context()).Warn("Payment event did not move order",
+			"event", event.ID, "order_id", order.ID, "order_status", order.OrderStatus.String, "wanted", input.OrderStatus)
+	} else {
+		switch input.OrderStatus {
+		case database.OrderPaid:
+			orderRevenue.Add(order.TotalAmount)
+		case database.OrderRefunded:
+			orderRefunds.Add(order.TotalAmount)
+		}
+	}
+
+	paymentWebhooks.WithLabelValues("applied").Inc()
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
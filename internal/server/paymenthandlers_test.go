@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"kaffino/internal/database"
+	"kaffino/internal/payments"
+)
+
+// paymentsDB applies payment events to a single pending order.
+type paymentsDB struct {
+	database.Service
+	order *database.OrderDetails
+	seen  map[string]bool
+}
+
+func (db *paymentsDB) ApplyPaymentEvent(ctx context.Context, event database.PaymentEventInput) (*database.OrderDetails, error) {
+	if db.seen[event.EventID] {
+		return nil, database.ErrDuplicatePaymentEvent
+	}
+	db.seen[event.EventID] = true
+	if event.IntentID != "pi_1" {
+		return nil, database.ErrPaymentNotFound
+	}
+	if database.CanTransition(database.OrderStatus(db.order.OrderStatus.String), event.OrderStatus) {
+		db.order.OrderStatus = sql.NullString{String: string(event.OrderStatus), Valid: true}
+	}
+	return db.order, nil
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	secret := []byte("whsec")
+	db := &paymentsDB{
+		order: &database.OrderDetails{Order: database.Order{ID: "o1", TotalAmount: 35.5, OrderStatus: sql.NullString{String: "Pending", Valid: true}}},
+		seen:  map[string]bool{},
+	}
+	s := &Server{db: db, payments: payments.NewFakeProvider("", secret), webhookSecret: secret}
+
+	post := func(body, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/payments", strings.NewReader(body))
+		req.Header.Set(payments.SignatureHeader, signature)
+		rec := httptest.NewRecorder()
+		s.paymentWebhookHandler(rec, req)
+		return rec
+	}
+
+	body := `{"id":"evt_1","type":"payment.succeeded","intent_id":"pi_1"}`
+	if rec := post(body, payments.Sign([]byte("forged"), time.Now(), []byte(body))); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a forged webhook to be rejected; got %d", rec.Code)
+	}
+	if db.order.OrderStatus.String != "Pending" {
+		t.Fatalf("expected a forged webhook to leave the order alone; got %s", db.order.OrderStatus.String)
+	}
+
+	revenue := testutil.ToFloat64(orderRevenue)
+	signature := payments.Sign(secret, time.Now(), []byte(body))
+	if rec := post(body, signature); rec.Code != http.StatusOK {
+		t.Errorf("expected the webhook to be applied; got %d %s", rec.Code, rec.Body)
+	}
+	if db.order.OrderStatus.String != "Paid" {
+		t.Errorf("expected the order to be paid; got %s", db.order.OrderStatus.String)
+	}
+
+	// Redeliveries are acknowledged so the provider stops retrying
+	if rec := post(body, signature); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "duplicate") {
+		t.Errorf("expected a duplicate to be acknowledged; got %d %s", rec.Code, rec.Body)
+	}
+	if got := testutil.ToFloat64(orderRevenue) - revenue; got != 35.5 {
+		t.Errorf("expected the paid order to count as revenue once; got %v", got)
+	}
+
+	body = `{"id":"evt_2","type":"payment.succeeded","intent_id":"pi_9"}`
+	if rec := post(body, payments.Sign(secret, time.Now(), []byte(body))); rec.Code != http.StatusNotFound {
+		t.Errorf("expected an unknown payment to be rejected; got %d", rec.Code)
+	}
+}
+
+// restartedDB holds a pending order whose payment was started before a
+// restart of the fake provider.
+type restartedDB struct {
+	database.Service
+	order    *database.OrderDetails
+	payments []*database.Payment
+}
+
+func (db *restartedDB) GetOrder(ctx context.Context, id string) (*database.OrderDetails, error) {
+	if id != db.order.ID {
+		return nil, database.ErrOrderNotFound
+	}
+	return db.order, nil
+}
+
+func (db *restartedDB) GetOrderPayment(ctx context.Context, orderID string) (*database.Payment, error) {
+	if len(db.payments) == 0 {
+		return nil, database.ErrPaymentNotFound
+	}
+	copied := *db.payments[len(db.payments)-1]
+	return &copied, nil
+}
+
+func (db *restartedDB) CreatePayment(ctx context.Context, payment *database.Payment) error {
+	payment.ID = "pay_new"
+	db.payments = append(db.payments, payment)
+	return nil
+}
+
+func (db *restartedDB) SetPaymentStatus(ctx context.Context, id, status string) error {
+	for _, p := range db.payments {
+		if p.ID == id {
+			p.Status = status
+			return nil
+		}
+	}
+	return database.ErrPaymentNotFound
+}
+
+func TestPaymentIntentLostOnRestart(t *testing.T) {
+	db := &restartedDB{
+		order: &database.OrderDetails{Order: database.Order{
+			ID: "o1", UserID: "u1", TotalAmount: 35.5, OrderStatus: sql.NullString{String: "Pending", Valid: true},
+		}},
+		payments: []*database.Payment{{ID: "pay_old", OrderID: "o1", Provider: "fake", IntentID: "fake_pi_gone", Status: "requires_capture"}},
+	}
+	s := &Server{db: db, payments: payments.NewFakeProvider("", []byte("whsec"))}
+
+	req := httptest.NewRequest(http.MethodPost, "/order/o1/payment/capture", nil)
+	req.SetPathValue("id", "o1")
+	rec := httptest.NewRecorder()
+	s.capturePaymentHandler(rec, req)
+	if rec.Code != http.StatusConflict || db.payments[0].Status != "expired" {
+		t.Errorf("expected capturing a lost intent to expire the payment; got %d, %s", rec.Code, db.payments[0].Status)
+	}
+
+	db.payments[0].Status = "requires_capture"
+	req = httptest.NewRequest(http.MethodPost, "/order/o1/payment", nil)
+	req.SetPathValue("id", "o1")
+	req = req.WithContext(context.WithValue(req.Context(), "userID", "u1"))
+	rec = httptest.NewRecorder()
+	s.createPaymentHandler(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected a new payment; got %d %s", rec.Code, rec.Body)
+	}
+	if db.payments[0].Status != "expired" || len(db.payments) != 2 || db.payments[1].IntentID == "fake_pi_gone" {
+		t.Errorf("expected the lost intent to be replaced; got %+v", db.payments)
+	}
+
+	// The new intent is live, so asking again returns it
+	rec = httptest.NewRecorder()
+	s.createPaymentHandler(rec, req)
+	if rec.Code != http.StatusOK || len(db.payments) != 2 {
+		t.Errorf("expected the new payment again; got %d, %d payments", rec.Code, len(db.payments))
+	}
+}
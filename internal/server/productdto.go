@@ -0,0 +1,190 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"kaffino/internal/database"
+)
+
+// productRequest is the body accepted by the product create and update
+// endpoints. It uses the same keys as productResponse, so a product fetched
+// from the API can be sent back unchanged.
+type productRequest struct {
+	Code        string   `json:"code"`
+	Images      []string `json:"images"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+}
+
+func (p productRequest) validate() error {
+	if p.Code == "" || p.Title == "" {
+		return errors.New("code and title are required")
+	}
+	return nil
+}
+
+func (p productRequest) toProduct(id string) *database.Product {
+	return &database.Product{
+		ID:          id,
+		Code:        p.Code,
+		Images:      database.EncodeImages(p.Images),
+		Title:       p.Title,
+		Description: sql.NullString{String: p.Description, Valid: p.Description != ""},
+	}
+}
+
+type variantResponse struct {
+	ID    string  `json:"id"`
+	Size  string  `json:"size"`
+	Price float64 `json:"price"`
+	Stock int64   `json:"stock"`
+}
+
+// productResponse is the product shape the frontend consumes.
+type productResponse struct {
+	ID              string             `json:"id"`
+	Code            string             `json:"code"`
+	Images          []string           `json:"images"`
+	Title           string             `json:"title"`
+	Description     string             `json:"description"`
+	LongDescription string             `json:"long_description"`
+	Discount        float64            `json:"discount"`
+	Rating          float64            `json:"rating"`
+	ReviewCount     int64              `json:"review_count"`
+	Reviews         []reviewResponse   `json:"reviews"`
+	Tags            []string           `json:"tags"`
+	Schedules       []string           `json:"shedules"` // spelled as the frontend expects
+	Sizes           []string           `json:"sizes"`
+	MapSizePrice    map[string]float64 `json:"map_size_price"`
+	StockQuantity   int64              `json:"stock_quantity"`
+	Variants        []variantResponse  `json:"variants"`
+	CreatedAt       string             `json:"created_at"`
+	UpdatedAt       string             `json:"updated_at"`
+}
+
+// productDetails is what a productResponse needs besides the product row.
+type productDetails struct {
+	Variants []database.Inventory
+	Tags     []string
+	Rating   database.RatingSummary
+	Reviews  []database.Review
+}
+
+func newProductResponse(product *database.Product, details productDetails) productResponse {
+	variants := details.Variants
+	resp := productResponse{
+		ID:           product.ID,
+		Code:         product.Code,
+		Images:       database.DecodeImages(product.Images),
+		Title:        product.Title,
+		Description:  product.Description.String,
+		Rating:       details.Rating.Average,
+		ReviewCount:  details.Rating.Count,
+		Reviews:      make([]reviewResponse, 0, len(details.Reviews)),
+		Tags:         append([]string{}, details.Tags...),
+		Schedules:    []string{},
+		Sizes:        make([]string, 0, len(variants)),
+		MapSizePrice: make(map[string]float64, len(variants)),
+		Variants:     make([]variantResponse, 0, len(variants)),
+		CreatedAt:    formatTime(product.CreatedAt),
+		UpdatedAt:    formatTime(product.UpdatedAt),
+	}
+	for i := range details.Reviews {
+		resp.Reviews = append(resp.Reviews, newReviewResponse(&details.Reviews[i]))
+	}
+	for _, v := range variants {
+		resp.Variants = append(resp.Variants, variantResponse{
+			ID:    v.ID,
+			Size:  v.Sizes.String,
+			Price: v.Price,
+			Stock: v.Stock,
+		})
+		resp.Sizes = append(resp.Sizes, v.Sizes.String)
+		resp.MapSizePrice[v.Sizes.String] = v.Price
+		resp.StockQuantity += v.Stock
+	}
+	return resp
+}
+
+// productPageResponse is one page of the product catalog.
+type productPageResponse struct {
+	Products   []productResponse `json:"products"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+// productSearchResult is a product matching a search. Snippet holds the
+// matching text with the matched terms wrapped in <mark> tags.
+type productSearchResult struct {
+	productResponse
+	Snippet string `json:"snippet"`
+}
+
+// productSearchResponse lists search results, most relevant first.
+type productSearchResponse struct {
+	Results []productSearchResult `json:"results"`
+}
+
+// parseProductFilter reads the catalog query parameters:
+// cursor, limit, sort (title, price, newest, rating), order (asc, desc),
+// tag, min_price, max_price and in_stock.
+func parseProductFilter(query url.Values) (database.ProductFilter, error) {
+	filter := database.ProductFilter{
+		Tag:    database.NormalizeTagName(query.Get("tag")),
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+	}
+
+	switch filter.Sort {
+	case "", database.SortTitle, database.SortPrice, database.SortNewest, database.SortRating:
+	default:
+		return filter, fmt.Errorf("unknown sort %q", filter.Sort)
+	}
+
+	if order := query.Get("order"); order != "" {
+		natural := "asc"
+		if filter.Sort == database.SortNewest || filter.Sort == database.SortRating {
+			natural = "desc"
+		}
+		if order != "asc" && order != "desc" {
+			return filter, fmt.Errorf("unknown order %q", order)
+		}
+		filter.Reverse = order != natural
+	}
+
+	var err error
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+	}
+	if v := query.Get("min_price"); v != "" {
+		if filter.MinPrice, err = strconv.ParseFloat(v, 64); err != nil || filter.MinPrice < 0 {
+			return filter, fmt.Errorf("invalid min_price %q", v)
+		}
+	}
+	if v := query.Get("max_price"); v != "" {
+		if filter.MaxPrice, err = strconv.ParseFloat(v, 64); err != nil || filter.MaxPrice < 0 {
+			return filter, fmt.Errorf("invalid max_price %q", v)
+		}
+	}
+	if v := query.Get("in_stock"); v != "" {
+		if filter.InStock, err = strconv.ParseBool(v); err != nil {
+			return filter, fmt.Errorf("invalid in_stock %q", v)
+		}
+	}
+
+	return filter, nil
+}
+
+// formatTime renders a nullable timestamp as RFC 3339, or "" when it is NULL.
+func formatTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.UTC().Format(time.RFC3339)
+}
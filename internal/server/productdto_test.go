@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestProductRoundTrip(t *testing.T) {
+	in := productRequest{
+		Code:        "BEAN001",
+		Images:      []string{"whole_bean1.jpg", "whole_bean2.jpg"},
+		Title:       "Peruvian Whole Bean Coffee",
+		Description: "High-altitude Arabica beans.",
+	}
+
+	// What a client receives...
+	body, err := json.Marshal(newProductResponse(in.toProduct("id-1"), productDetails{}))
+	if err != nil {
+		t.Fatalf("error marshaling product. Err: %v", err)
+	}
+
+	// ...can be sent back as an update without changing anything
+	var out productRequest
+	if err := json.Unmarshal(body, &out); err != nil {
+		t.Fatalf("error unmarshaling product. Err: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("expected %+v; got %+v", in, out)
+	}
+
+	var keys map[string]interface{}
+	if err := json.Unmarshal(body, &keys); err != nil {
+		t.Fatalf("error unmarshaling product. Err: %v", err)
+	}
+	for _, key := range []string{"id", "code", "images", "title", "description", "created_at", "updated_at", "map_size_price"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("expected key %q in %s", key, body)
+		}
+	}
+}
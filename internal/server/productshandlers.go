@@ -1,39 +1,38 @@
 package server
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
-	"kaffino/internal/database"
-	"log"
+	"errors"
 	"net/http"
+	"strconv"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
 )
 
 func (s *Server) createProductHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the request body
-	product := &database.Product{}
-	if err := json.NewDecoder(r.Body).Decode(product); err != nil {
+	var req productRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
 		return
 	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Create the product
+	product := req.toProduct("")
 	if err := s.db.CreateProduct(r.Context(), product); err != nil {
-		log.Printf("Failed to create product: %v", err)
+		logging.FromContext(r.Context()).Error("Failed to create product", "error", err)
 		http.Error(w, "Failed to create product", http.StatusInternalServerError)
 		return
 	}
 
-	// Marshal the response
-	jsonResp, err := json.Marshal(product)
-	if err != nil {
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
-		return
-	}
-
-	// Write the response
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(jsonResp); err != nil {
-		log.Printf("Failed to write response: %v", err)
-	}
+	writeJSON(w, http.StatusCreated, newProductResponse(product, productDetails{}))
 }
 
 func (s *Server) getProductByIDHandler(w http.ResponseWriter, r *http.Request) {
@@ -48,13 +47,33 @@ func (s *Server) getProductByIDHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the product from the database
 	product, err := s.db.GetProduct(r.Context(), id)
 	if err != nil {
-		log.Printf("Failed to get product: %v", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to get product", "error", err)
+		http.Error(w, "Failed to get product", http.StatusInternalServerError)
+		return
+	}
+
+	details, err := s.productDetails(r.Context(), product.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to get product details", "error", err)
+		http.Error(w, "Failed to get product", http.StatusInternalServerError)
+		return
+	}
+
+	reviews, err := s.db.ListReviews(r.Context(), product.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to get product reviews", "error", err)
 		http.Error(w, "Failed to get product", http.StatusInternalServerError)
 		return
 	}
+	detail := details[product.ID]
+	detail.Reviews = reviews
 
 	// Marshal the response
-	jsonResp, err := json.Marshal(product)
+	jsonResp, err := json.Marshal(newProductResponse(product, detail))
 	if err != nil {
 		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
 		return
@@ -63,59 +82,160 @@ func (s *Server) getProductByIDHandler(w http.ResponseWriter, r *http.Request) {
 	// Write the response
 	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(jsonResp); err != nil {
-		log.Printf("Failed to write response: %v", err)
+		logging.FromContext(r.Context()).Error("Failed to write response", "error", err)
 	}
 }
 
 func (s *Server) listProductsHandler(w http.ResponseWriter, r *http.Request) {
-	// List all products
-	products, err := s.db.ListProducts(r.Context())
+	filter, err := parseProductFilter(r.URL.Query())
 	if err != nil {
-		log.Println(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// List one page of products
+	page, err := s.db.ListProducts(r.Context(), filter)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to list products", "error", err)
 		http.Error(w, "Failed to list products", http.StatusInternalServerError)
 		return
 	}
 
-	// Marshal the response
-	jsonResp, err := json.Marshal(products)
+	products, err := s.productResponses(r.Context(), page.Products)
 	if err != nil {
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error("Failed to list products", "error", err)
+		http.Error(w, "Failed to list products", http.StatusInternalServerError)
 		return
 	}
 
-	// Write the response
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(jsonResp); err != nil {
-		log.Printf("Failed to write response: %v", err)
+	writeJSON(w, http.StatusOK, productPageResponse{Products: products, NextCursor: page.NextCursor})
+}
+
+func (s *Server) searchProductsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Missing search query", http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	results, err := s.db.SearchProducts(r.Context(), query, limit)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to search products", "error", err)
+		http.Error(w, "Failed to search products", http.StatusInternalServerError)
+		return
+	}
+
+	products := make([]*database.Product, 0, len(results))
+	for i := range results {
+		products = append(products, &results[i].Product)
 	}
+	responses, err := s.productResponses(r.Context(), products)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to search products", "error", err)
+		http.Error(w, "Failed to search products", http.StatusInternalServerError)
+		return
+	}
+
+	resp := productSearchResponse{Results: make([]productSearchResult, 0, len(results))}
+	for i, product := range responses {
+		resp.Results = append(resp.Results, productSearchResult{productResponse: product, Snippet: results[i].Snippet})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// productResponses builds the responses for a list of products, loading
+// their details in one query per kind rather than one per product.
+func (s *Server) productResponses(ctx context.Context, products []*database.Product) ([]productResponse, error) {
+	ids := make([]string, 0, len(products))
+	for _, product := range products {
+		ids = append(ids, product.ID)
+	}
+	details, err := s.productDetails(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]productResponse, 0, len(products))
+	for _, product := range products {
+		resp = append(resp, newProductResponse(product, details[product.ID]))
+	}
+	return resp, nil
+}
+
+// productDetails loads the variants, tags and ratings of the given products,
+// keyed by product ID. Reviews are only loaded for a single product page.
+func (s *Server) productDetails(ctx context.Context, ids ...string) (map[string]productDetails, error) {
+	variants, err := s.db.ListVariants(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := s.db.ListProductTags(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+	ratings, err := s.db.GetRatingSummaries(ctx, ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	details := make(map[string]productDetails, len(ids))
+	for _, id := range ids {
+		details[id] = productDetails{Variants: variants[id], Tags: tags[id], Rating: ratings[id]}
+	}
+	return details, nil
 }
 
 func (s *Server) updateProductHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the product ID from the URL
+	id := r.PathValue("id")
+	if id == "" {
+		http.Error(w, "Missing product ID", http.StatusBadRequest)
+		return
+	}
+
 	// Parse the request body
-	product := &database.Product{}
-	if err := json.NewDecoder(r.Body).Decode(product); err != nil {
+	var req productRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
 		return
 	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Update the product
-	if err := s.db.UpdateProduct(r.Context(), product); err != nil {
+	if err := s.db.UpdateProduct(r.Context(), req.toProduct(id)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to update product", "error", err)
 		http.Error(w, "Failed to update product", http.StatusInternalServerError)
 		return
 	}
 
-	// Marshal the response
-	jsonResp, err := json.Marshal(product)
+	// Read the product back so the response has the stored values
+	product, err := s.db.GetProduct(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error("Failed to get product", "error", err)
+		http.Error(w, "Failed to get product", http.StatusInternalServerError)
 		return
 	}
-
-	// Write the response
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(jsonResp); err != nil {
-		log.Printf("Failed to write response: %v", err)
+	details, err := s.productDetails(r.Context(), id)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to get product details", "error", err)
+		http.Error(w, "Failed to get product", http.StatusInternalServerError)
+		return
 	}
+
+	writeJSON(w, http.StatusOK, newProductResponse(product, details[id]))
 }
 
 func (s *Server) deleteProductHandler(w http.ResponseWriter, r *http.Request) {
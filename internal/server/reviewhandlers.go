@@ -0,0 +1,153 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+	"kaffino/internal/server/auth"
+)
+
+type reviewRequest struct {
+	Rating  int64  `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+type reviewResponse struct {
+	ID        string `json:"id"`
+	ProductID string `json:"product_id"`
+	UserID    string `json:"user_id"`
+	Rating    int64  `json:"rating"`
+	Comment   string `json:"comment"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func newReviewResponse(review *database.Review) reviewResponse {
+	return reviewResponse{
+		ID:        review.ID,
+		ProductID: review.ProductID,
+		UserID:    review.UserID,
+		Rating:    review.Rating,
+		Comment:   review.Comment.String,
+		CreatedAt: formatTime(review.CreatedAt),
+		UpdatedAt: formatTime(review.UpdatedAt),
+	}
+}
+
+// writeReviewError maps review errors to their HTTP status.
+func writeReviewError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, database.ErrInvalidReview):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrReviewExists):
+		http.Error(w, "Product already reviewed", http.StatusConflict)
+	case errors.Is(err, database.ErrReviewNotFound):
+		http.Error(w, "Review not found", http.StatusNotFound)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	default:
+		logging.FromContext(r.Context()).Error(msg, "error", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	reviews, err := s.db.ListReviews(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeReviewError(w, r, err, "Failed to list reviews")
+		return
+	}
+
+	resp := make([]reviewResponse, 0, len(reviews))
+	for i := range reviews {
+		resp = append(resp, newReviewResponse(&reviews[i]))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required to review a product", http.StatusUnauthorized)
+		return
+	}
+
+	var req reviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	productID := r.PathValue("id")
+	if s.reviewsRequirePurchase {
+		delivered, err := s.db.HasDeliveredOrder(r.Context(), userID, productID)
+		if err != nil {
+			writeReviewError(w, r, err, "Failed to create review")
+			return
+		}
+		if !delivered {
+			http.Error(w, "Only customers who received this product can review it", http.StatusForbidden)
+			return
+		}
+	}
+
+	review := &database.Review{
+		ProductID: productID,
+		UserID:    userID,
+		Rating:    req.Rating,
+		Comment:   sql.NullString{String: req.Comment, Valid: req.Comment != ""},
+	}
+	if err := s.db.CreateReview(r.Context(), review); err != nil {
+		writeReviewError(w, r, err, "Failed to create review")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newReviewResponse(review))
+}
+
+func (s *Server) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	var req reviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	review := &database.Review{
+		ID:        r.PathValue("reviewId"),
+		ProductID: r.PathValue("id"),
+		UserID:    userID,
+		Rating:    req.Rating,
+		Comment:   sql.NullString{String: req.Comment, Valid: req.Comment != ""},
+	}
+	if err := s.db.UpdateReview(r.Context(), review); err != nil {
+		writeReviewError(w, r, err, "Failed to update review")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newReviewResponse(review))
+}
+
+func (s *Server) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.db.DeleteReview(r.Context(), userID, r.PathValue("id"), r.PathValue("reviewId")); err != nil {
+		writeReviewError(w, r, err, "Failed to delete review")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
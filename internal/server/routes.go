@@ -3,12 +3,15 @@ package server
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/coder/websocket"
 
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+	"kaffino/internal/metrics"
 	"kaffino/internal/server/auth"
 )
 
@@ -18,24 +21,85 @@ func (s *Server) RegisterRoutes() http.Handler {
 	// Register routes
 	mux.HandleFunc("/", s.HelloWorldHandler)
 
-	mux.HandleFunc("/health", s.healthHandler)
-
 	mux.HandleFunc("/websocket", s.websocketHandler)
 
-	mux.HandleFunc("POST /product", s.createProductHandler)
+	// Catalog and fulfillment changes are for staff, role changes for admins
+	staff := auth.RequireRole(s.db, database.RoleStaff)
+	admin := auth.RequireRole(s.db, database.RoleAdmin)
+
+	mux.Handle("POST /product", staff(s.createProductHandler))
 	mux.HandleFunc("GET /product/{id}", s.getProductByIDHandler)
-	mux.HandleFunc("PUT /product/{id}", s.updateProductHandler)
-	mux.HandleFunc("DELETE /product/{id}", s.deleteProductHandler)
+	mux.Handle("PUT /product/{id}", staff(s.updateProductHandler))
+	mux.Handle("DELETE /product/{id}", staff(s.deleteProductHandler))
 	mux.HandleFunc("GET /products", s.listProductsHandler)
+	mux.HandleFunc("GET /products/search", s.searchProductsHandler)
+	mux.Handle("POST /product/{id}/variants", staff(s.createVariantHandler))
+	mux.Handle("PUT /product/{id}/variants/{variantId}", staff(s.updateVariantHandler))
+	mux.Handle("DELETE /product/{id}/variants/{variantId}", staff(s.deleteVariantHandler))
+	mux.Handle("POST /product/{id}/tags", staff(s.attachTagHandler))
+	mux.Handle("DELETE /product/{id}/tags/{name}", staff(s.detachTagHandler))
+	mux.HandleFunc("GET /product/{id}/reviews", s.listReviewsHandler)
+	mux.HandleFunc("POST /product/{id}/reviews", s.createReviewHandler)
+	mux.HandleFunc("PUT /product/{id}/reviews/{reviewId}", s.updateReviewHandler)
+	mux.HandleFunc("DELETE /product/{id}/reviews/{reviewId}", s.deleteReviewHandler)
+
+	mux.HandleFunc("GET /tags", s.listTagsHandler)
+	mux.Handle("POST /tags", staff(s.createTagHandler))
+	mux.Handle("PUT /tags/{id}", staff(s.renameTagHandler))
+	mux.Handle("DELETE /tags/{id}", staff(s.deleteTagHandler))
+	mux.HandleFunc("GET /tags/{name}/products", s.listTagProductsHandler)
+
+	mux.HandleFunc("POST /order", s.idempotent(s.createOrderHandler))
+	mux.HandleFunc("GET /order/{id}", s.getOrderHandler)
+	mux.HandleFunc("GET /orders", s.listOrdersHandler)
+	mux.Handle("PATCH /order/{id}/status", staff(s.updateOrderStatusHandler))
+	mux.HandleFunc("POST /order/{id}/payment", s.idempotent(s.createPaymentHandler))
+	mux.Handle("POST /order/{id}/payment/capture", staff(s.idempotent(s.capturePaymentHandler)))
+	mux.Handle("POST /order/{id}/refund", staff(s.idempotent(s.refundPaymentHandler)))
+
+	mux.Handle("POST /discounts", staff(s.createDiscountHandler))
+	mux.Handle("GET /discounts", staff(s.listDiscountsHandler))
+	mux.Handle("GET /discounts/{id}", staff(s.getDiscountHandler))
+	mux.Handle("PUT /discounts/{id}", staff(s.updateDiscountHandler))
+
+	mux.HandleFunc("POST /subscriptions", s.idempotent(s.createSubscriptionHandler))
+	mux.HandleFunc("GET /subscriptions", s.listSubscriptionsHandler)
+	mux.HandleFunc("GET /subscriptions/{id}", s.getSubscriptionHandler)
+	mux.HandleFunc("POST /subscriptions/{id}/pause", s.changeSubscriptionHandler(s.db.PauseSubscription))
+	mux.HandleFunc("POST /subscriptions/{id}/resume", s.changeSubscriptionHandler(s.resumeSubscription))
+	mux.HandleFunc("POST /subscriptions/{id}/skip", s.changeSubscriptionHandler(s.skipSubscription))
+	mux.HandleFunc("POST /subscriptions/{id}/cancel", s.changeSubscriptionHandler(s.db.CancelSubscription))
+
+	mux.HandleFunc("GET /cart", s.getCartHandler)
+	mux.HandleFunc("POST /cart", s.addCartItemHandler)
+	mux.HandleFunc("PATCH /cart/{itemId}", s.updateCartItemHandler)
+	mux.HandleFunc("DELETE /cart/{itemId}", s.removeCartItemHandler)
+	mux.HandleFunc("DELETE /cart", s.clearCartHandler)
+
+	mux.Handle("GET /admin/diagnostics", admin(s.diagnosticsHandler))
+	mux.Handle("PUT /users/{id}/role", admin(s.setUserRoleHandler))
+	mux.Handle("DELETE /users/{id}/sessions", admin(s.auth.RevokeUserSessionsHandler))
 
 	// OTP, login route
-	mux.HandleFunc("POST /login", auth.LoginHandler)
-	mux.HandleFunc("POST /verify-otp", auth.VerifyOTPHandler)
-	mux.HandleFunc("GET /logout", auth.LogoutHandler)
+	mux.HandleFunc("POST /login", s.auth.LoginHandler)
+	mux.HandleFunc("POST /verify-otp", s.auth.VerifyOTPHandler)
+	mux.HandleFunc("GET /login/verify", s.auth.VerifyLoginLinkHandler)
+	mux.HandleFunc("GET /logout", s.auth.LogoutHandler)
+	mux.HandleFunc("GET /sessions", s.auth.ListSessionsHandler)
+	mux.HandleFunc("DELETE /sessions/{id}", s.auth.RevokeSessionHandler)
 	// Wrap the mux with CORS middleware}
 
-	wrap := auth.SessionMiddleware(mux)
-	return s.corsMiddleware(wrap)
+	wrap := s.auth.SessionMiddleware(metrics.InstrumentMux(mux))
+
+	// Probes, scrapes and webhooks skip the session middleware, so they do
+	// not create sessions
+	root := http.NewServeMux()
+	root.HandleFunc("GET /livez", s.livezHandler)
+	root.HandleFunc("GET /readyz", s.readyzHandler)
+	root.Handle("GET /metrics", metrics.Handler(s.metricsToken))
+	root.HandleFunc("POST /webhooks/payments", s.paymentWebhookHandler)
+	root.Handle("/", wrap)
+	return logging.Middleware(slog.Default())(s.corsMiddleware(root))
 }
 
 func (s *Server) corsMiddleware(next http.Handler) http.Handler {
@@ -43,7 +107,8 @@ func (s *Server) corsMiddleware(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Replace "*" with specific origins if needed
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, X-CSRF-Token, X-Request-ID, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Idempotent-Replayed")
 		w.Header().Set("Access-Control-Allow-Credentials", "false") // Set to "true" if credentials are required
 
 		// Handle preflight OPTIONS requests
@@ -66,19 +131,7 @@ func (s *Server) HelloWorldHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(jsonResp); err != nil {
-		log.Printf("Failed to write response: %v", err)
-	}
-}
-
-func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	resp, err := json.Marshal(s.db.Health())
-	if err != nil {
-		http.Error(w, "Failed to marshal health check response", http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	if _, err := w.Write(resp); err != nil {
-		log.Printf("Failed to write response: %v", err)
+		logging.FromContext(r.Context()).Error("Failed to write response", "error", err)
 	}
 }
 
@@ -96,7 +149,7 @@ func (s *Server) websocketHandler(w http.ResponseWriter, r *http.Request) {
 	for {
 		payload := fmt.Sprintf("server timestamp: %d", time.Now().UnixNano())
 		if err := socket.Write(socketCtx, websocket.MessageText, []byte(payload)); err != nil {
-			log.Printf("Failed to write to socket: %v", err)
+			logging.FromContext(r.Context()).Error("Failed to write to socket", "error", err)
 			break
 		}
 		time.Sleep(2 * time.Second)
@@ -1,32 +1,99 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"kaffino/internal/config"
 	"kaffino/internal/database"
+	"kaffino/internal/payments"
+	"kaffino/internal/server/auth"
+	"kaffino/internal/tax"
 )
 
+// reservationTTL is how long an unpaid order keeps its stock reserved.
+const reservationTTL = 30 * time.Minute
+
 type Server struct {
 	port int
 
-	db database.Service
+	db     database.Service
+	auth   *auth.Handlers
+	mailer auth.EmailSender
+
+	payments payments.PaymentProvider
+	// webhookSecret verifies the signatures of payment webhooks.
+	webhookSecret []byte
+
+	startedAt time.Time
+
+	// reviewsRequirePurchase only lets customers review products they
+	// have received.
+	reviewsRequirePurchase bool
+
+	// metricsToken, when set, must be sent as a bearer token to scrape
+	// /metrics.
+	metricsToken string
+
+	// charges are the shipping fee and the tax rule of every order.
+	charges database.Charges
 }
 
-func NewServer() *http.Server {
-	port := 8080 
+// NewServer builds the HTTP server from a validated configuration.
+func NewServer(cfg *config.Config) (*http.Server, error) {
+	mailer, err := auth.NewEmailSender(context.Background(), cfg.Email)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up email delivery: %w", err)
+	}
+
+	provider, err := payments.NewProvider(cfg.Payments.Provider, cfg.Payments.WebhookURL, []byte(cfg.Payments.WebhookSecret))
+	if err != nil {
+		return nil, fmt.Errorf("error setting up payments: %w", err)
+	}
+
+	if cfg.Env == config.EnvProduction && cfg.Payments.Provider == config.PaymentProviderFake {
+		slog.Warn("RUNNING IN PRODUCTION WITH THE FAKE PAYMENT PROVIDER, ORDERS ARE NOT CHARGED")
+	}
+
 	NewServer := &Server{
-		port: port,
+		port: cfg.Port,
+
+		db:     database.NewDB(cfg.DatabaseURL),
+		mailer: mailer,
+
+		payments:      provider,
+		webhookSecret: []byte(cfg.Payments.WebhookSecret),
 
-		db: database.NewDB(),
+		startedAt: time.Now(),
+
+		reviewsRequirePurchase: cfg.ReviewsRequirePurchase,
+		metricsToken:           cfg.MetricsToken,
+		charges: database.Charges{
+			Shipping: cfg.ShippingFee,
+			Tax:      tax.Rule{Rate: cfg.Tax.Rate, Included: cfg.Tax.PricesIncludeTax},
+		},
+	}
+	NewServer.auth = auth.NewHandlers(cfg, NewServer.db, NewServer.db, NewServer.db, mailer)
+	if cfg.AutoMigrate {
+		if _, err := NewServer.db.MigrateUp(context.Background()); err != nil {
+			return nil, fmt.Errorf("error migrating database: %w", err)
+		}
 	}
-	err := NewServer.db.DbInit()
+	err = NewServer.db.DbInit()
 	if err != nil {
-		fmt.Println(err)
+		slog.Error("Failed to populate the database", "error", err)
 	}
+	NewServer.promoteAdmins(cfg.AdminEmails)
+	go NewServer.expireReservations()
+	go NewServer.auth.SweepChallenges(time.Minute)
+	go NewServer.auth.SweepSessions(time.Hour)
+	go NewServer.sweepIdempotencyKeys(time.Hour)
+	go NewServer.runSubscriptions(subscriptionInterval)
 	// Declare Server config
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", NewServer.port),
@@ -36,5 +103,40 @@ func NewServer() *http.Server {
 		WriteTimeout: 30 * time.Second,
 	}
 
-	return server
+	return server, nil
+}
+
+// promoteAdmins gives the admin role to the users with the given emails,
+// creating their accounts if needed. It is how the first admin gets in;
+// later role changes go through PUT /users/{id}/role.
+func (s *Server) promoteAdmins(emails []string) {
+	for _, email := range emails {
+		userID, err := s.db.GetUserID(context.Background(), email)
+		if err != nil {
+			slog.Error("Failed to get admin user", "email", email, "error", err)
+			continue
+		}
+		if err := s.db.SetUserRole(context.Background(), userID, database.RoleAdmin); err != nil {
+			slog.Error("Failed to promote admin", "email", email, "error", err)
+		}
+	}
+}
+
+// expireReservations periodically cancels unpaid orders whose stock
+// reservation has run out, putting their units back on sale. Orders whose
+// payment is waiting to be captured keep their reservation.
+func (s *Server) expireReservations() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := s.db.ExpirePendingOrders(context.Background(), time.Now().Add(-reservationTTL))
+		if err != nil {
+			slog.Error("Failed to expire pending orders", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("Expired unpaid orders", "count", n)
+		}
+	}
 }
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+	"kaffino/internal/server/auth"
+)
+
+type subscriptionRequest struct {
+	VariantID string `json:"variant_id"`
+	Quantity  int64  `json:"quantity"`
+	Cadence   string `json:"cadence"`
+	// NextRunAt is when the first order is placed, right away when empty.
+	NextRunAt       *time.Time `json:"next_run_at"`
+	ShippingAddress string     `json:"shipping_address"`
+	BillingAddress  string     `json:"billing_address"`
+	PaymentMethod   string     `json:"payment_method"`
+}
+
+type subscriptionResponse struct {
+	ID              string    `json:"id"`
+	ProductID       string    `json:"product_id"`
+	ProductTitle    string    `json:"product_title"`
+	VariantID       string    `json:"variant_id"`
+	Size            string    `json:"size"`
+	Price           float64   `json:"price"`
+	Quantity        int64     `json:"quantity"`
+	Cadence         string    `json:"cadence"`
+	Status          string    `json:"status"`
+	NextRunAt       time.Time `json:"next_run_at"`
+	ShippingAddress string    `json:"shipping_address,omitempty"`
+	BillingAddress  string    `json:"billing_address,omitempty"`
+	PaymentMethod   string    `json:"payment_method,omitempty"`
+	LastOrderID     string    `json:"last_order_id,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func newSubscriptionResponse(sub *database.SubscriptionDetails) subscriptionResponse {
+	return subscriptionResponse{
+		ID:              sub.ID,
+		ProductID:       sub.ProductID,
+		ProductTitle:    sub.ProductTitle,
+		VariantID:       sub.InventoryID,
+		Size:            sub.Size,
+		Price:           sub.Price,
+		Quantity:        sub.Quantity,
+		Cadence:         sub.Cadence,
+		Status:          sub.Status,
+		NextRunAt:       sub.NextRunAt,
+		ShippingAddress: sub.ShippingAddress.String,
+		BillingAddress:  sub.BillingAddress.String,
+		PaymentMethod:   sub.PaymentMethod.String,
+		LastOrderID:     sub.LastOrderID.String,
+		CreatedAt:       sub.CreatedAt.Time,
+	}
+}
+
+func (s *Server) createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required to subscribe", http.StatusUnauthorized)
+		return
+	}
+
+	var req subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	cadence, err := database.ParseCadence(req.Cadence)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input := database.SubscriptionInput{
+		VariantID:       req.VariantID,
+		Quantity:        req.Quantity,
+		Cadence:         cadence,
+		ShippingAddress: req.ShippingAddress,
+		BillingAddress:  req.BillingAddress,
+		PaymentMethod:   req.PaymentMethod,
+	}
+	if req.NextRunAt != nil {
+		input.NextRunAt = *req.NextRunAt
+	}
+
+	sub, err := s.db.CreateSubscription(r.Context(), userID, input)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrInvalidSubscription):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, database.ErrVariantNotFound):
+			http.Error(w, "Variant not found", http.StatusBadRequest)
+		default:
+			logging.FromContext(r.Context()).Error("Failed to create subscription", "error", err)
+			http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newSubscriptionResponse(sub))
+}
+
+func (s *Server) listSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	subs, err := s.db.ListSubscriptions(r.Context(), userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to list subscriptions", "error", err)
+		http.Error(w, "Failed to list subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]subscriptionResponse, 0, len(subs))
+	for i := range subs {
+		resp = append(resp, newSubscriptionResponse(&subs[i]))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) getSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth.UserIDFromContext(r.Context())
+	if !ok || auth.IsGuest(userID) {
+		http.Error(w, "Login required", http.StatusUnauthorized)
+		return
+	}
+
+	sub, err := s.db.GetSubscription(r.Context(), r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, database.ErrSubscriptionNotFound) {
+			http.Error(w, "Subscription not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to get subscription", "error", err)
+		http.Error(w, "Failed to get subscription", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscriptions of other users are reported as missing rather than forbidden
+	if sub.UserID != userID {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newSubscriptionResponse(sub))
+}
+
+// changeSubscriptionHandler serves the pause, resume, skip and cancel
+// actions on one of the user's subscriptions.
+func (s *Server) changeSubscriptionHandler(change func(ctx context.Context, userID, id string) (*database.SubscriptionDetails, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := auth.UserIDFromContext(r.Context())
+		if !ok || auth.IsGuest(userID) {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+
+		sub, err := change(r.Context(), userID, r.PathValue("id"))
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrSubscriptionNotFound):
+				http.Error(w, "Subscription not found", http.StatusNotFound)
+			case errors.Is(err, database.ErrSubscriptionState):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				logging.FromContext(r.Context()).Error("Failed to update subscription", "error", err)
+				http.Error(w, "Failed to update subscription", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newSubscriptionResponse(sub))
+	}
+}
+
+func (s *Server) resumeSubscription(ctx context.Context, userID, id string) (*database.SubscriptionDetails, error) {
+	return s.db.ResumeSubscription(ctx, userID, id, time.Now())
+}
+
+func (s *Server) skipSubscription(ctx context.Context, userID, id string) (*database.SubscriptionDetails, error) {
+	return s.db.SkipSubscription(ctx, userID, id, time.Now())
+}
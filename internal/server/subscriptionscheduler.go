@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"kaffino/internal/database"
+)
+
+const (
+	// subscriptionInterval is how often the scheduler looks for due
+	// subscriptions.
+	subscriptionInterval = 5 * time.Minute
+	// subscriptionReminderLead is how long before an order is placed its
+	// customer gets a reminder, time enough to skip or pause.
+	subscriptionReminderLead = 48 * time.Hour
+)
+
+var subscriptionRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "kaffino_subscription_runs_total",
+	Help: "Subscription runs, by result.",
+}, []string{"result"})
+
+// limaTime is the time zone delivery dates are written in.
+var limaTime = time.FixedZone("PET", -5*60*60)
+
+// runSubscriptions periodically reminds customers of upcoming subscription
+// orders and places the due ones. The first pass runs at startup so orders
+// that fell due while the server was down are not held up.
+func (s *Server) runSubscriptions(interval time.Duration) {
+	s.processSubscriptions(context.Background(), time.Now())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.processSubscriptions(context.Background(), time.Now())
+	}
+}
+
+// processSubscriptions does one pass of the scheduler at now.
+func (s *Server) processSubscriptions(ctx context.Context, now time.Time) {
+	upcoming, err := s.db.ListSubscriptionsToRemind(ctx, now, now.Add(subscriptionReminderLead))
+	if err != nil {
+		slog.Error("Failed to list subscriptions to remind", "error", err)
+	}
+	for i := range upcoming {
+		s.remindSubscription(ctx, &upcoming[i])
+	}
+
+	due, err := s.db.ListDueSubscriptions(ctx, now)
+	if err != nil {
+		slog.Error("Failed to list due subscriptions", "error", err)
+		return
+	}
+	for i := range due {
+		s.placeSubscriptionOrder(ctx, &due[i], now)
+	}
+}
+
+// remindSubscription emails the customer about the next order of a
+// subscription. The reminder is only marked as sent once it went out, so a
+// failed one is tried again on the next pass.
+func (s *Server) remindSubscription(ctx context.Context, sub *database.SubscriptionDetails) {
+	subject := "Your Kaffino subscription is on its way"
+	amount := sub.Price*float64(sub.Quantity) + s.charges.Shipping
+	if !s.charges.Tax.Included {
+		_, igv := s.charges.Tax.Split(amount)
+		amount += igv
+	}
+	body := fmt.Sprintf("Your next %s order of %d x %s (%s) will be placed on %s, for %s.\n\n"+
+		"To skip this delivery, pause or cancel your subscription, do so before then.",
+		sub.Cadence, sub.Quantity, sub.ProductTitle, sub.Size, sub.NextRunAt.In(limaTime).Format("Monday 2 January"),
+		soles(amount))
+	if err := s.mailer.Send(ctx, sub.Email, subject, body); err != nil {
+		slog.Error("Failed to send subscription reminder", "subscription_id", sub.ID, "error", err)
+		return
+	}
+	if err := s.db.MarkSubscriptionReminded(ctx, sub.ID); err != nil {
+		slog.Error("Failed to mark subscription reminded", "subscription_id", sub.ID, "error", err)
+	}
+}
+
+// placeSubscriptionOrder places the order of a due subscription and charges
+// it. When the variant is out of stock or gone, the run is skipped and the
+// customer told.
+func (s *Server) placeSubscriptionOrder(ctx context.Context, sub *database.SubscriptionDetails, now time.Time) {
+	order, err := s.db.RunSubscription(ctx, sub.ID, s.charges, now)
+	switch {
+	case errors.Is(err, database.ErrSubscriptionNotDue):
+		return
+	case errors.Is(err, database.ErrInsufficientStock), errors.Is(err, database.ErrVariantNotFound), errors.Is(err, database.ErrInvalidOrder):
+		subscriptionRuns.WithLabelValues("skipped").Inc()
+		slog.Warn("Skipped subscription run", "subscription_id", sub.ID, "error", err)
+		s.skipUnavailableRun(ctx, sub, now)
+		return
+	case err != nil:
+		subscriptionRuns.WithLabelValues("failed").Inc()
+		slog.Error("Failed to run subscription", "subscription_id", sub.ID, "error", err)
+		return
+	}
+
+	subscriptionRuns.WithLabelValues("placed").Inc()
+	ordersPlaced.Inc()
+	slog.Info("Placed subscription order", "subscription_id", sub.ID, "order_id", order.ID)
+	s.sendOrderConfirmation(ctx, sub.Email, order)
+
+	// Subscriptions are charged without the customer at hand; an order
+	// whose charge fails stays pending and expires with its reservation
+	payment, err := s.startPayment(ctx, order)
+	if err != nil {
+		slog.Error("Failed to charge subscription order", "order_id", order.ID, "error", err)
+		return
+	}
+	if _, err := s.payments.Capture(ctx, payment.IntentID); err != nil {
+		slog.Error("Failed to charge subscription order", "order_id", order.ID, "error", err)
+	}
+}
+
+func (s *Server) skipUnavailableRun(ctx context.Context, sub *database.SubscriptionDetails, now time.Time) {
+	skipped, err := s.db.SkipSubscription(ctx, sub.UserID, sub.ID, now)
+	if err != nil {
+		slog.Error("Failed to skip subscription run", "subscription_id", sub.ID, "error", err)
+		return
+	}
+
+	subject := "Your Kaffino subscription delivery was skipped"
+	body := fmt.Sprintf("We could not place your %s order of %d x %s (%s) because it is out of stock, "+
+		"so this delivery was skipped and you were not charged. Your next order will be placed on %s.",
+		sub.Cadence, sub.Quantity, sub.ProductTitle, sub.Size, skipped.NextRunAt.In(limaTime).Format("Monday 2 January"))
+	if err := s.mailer.Send(ctx, sub.Email, subject, body); err != nil {
+		slog.Error("Failed to send skipped subscription email", "subscription_id", sub.ID, "error", err)
+	}
+}
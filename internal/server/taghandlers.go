@@ -0,0 +1,165 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+)
+
+type tagRequest struct {
+	Name string `json:"name"`
+}
+
+type tagResponse struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func newTagResponse(tag *database.Tag) tagResponse {
+	return tagResponse{
+		ID:        tag.ID,
+		Name:      tag.Name,
+		CreatedAt: formatTime(tag.CreatedAt),
+		UpdatedAt: formatTime(tag.UpdatedAt),
+	}
+}
+
+// writeTagError maps tag errors to their HTTP status.
+func writeTagError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, database.ErrInvalidTag):
+		http.Error(w, "Invalid tag name", http.StatusBadRequest)
+	case errors.Is(err, database.ErrTagExists):
+		http.Error(w, "Tag already exists", http.StatusConflict)
+	case errors.Is(err, database.ErrTagNotFound):
+		http.Error(w, "Tag not found", http.StatusNotFound)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Product not found", http.StatusNotFound)
+	default:
+		logging.FromContext(r.Context()).Error(msg, "error", err)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) listTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.db.ListTags(r.Context())
+	if err != nil {
+		writeTagError(w, r, err, "Failed to list tags")
+		return
+	}
+
+	resp := make([]tagResponse, 0, len(tags))
+	for i := range tags {
+		resp = append(resp, newTagResponse(&tags[i]))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) createTagHandler(w http.ResponseWriter, r *http.Request) {
+	var req tagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := s.db.CreateTag(r.Context(), req.Name)
+	if err != nil {
+		writeTagError(w, r, err, "Failed to create tag")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newTagResponse(tag))
+}
+
+func (s *Server) renameTagHandler(w http.ResponseWriter, r *http.Request) {
+	var req tagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := s.db.RenameTag(r.Context(), r.PathValue("id"), req.Name)
+	if err != nil {
+		writeTagError(w, r, err, "Failed to rename tag")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newTagResponse(tag))
+}
+
+func (s *Server) deleteTagHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.DeleteTag(r.Context(), r.PathValue("id")); err != nil {
+		writeTagError(w, r, err, "Failed to delete tag")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) attachTagHandler(w http.ResponseWriter, r *http.Request) {
+	var req tagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	tag, err := s.db.AttachTag(r.Context(), r.PathValue("id"), req.Name)
+	if err != nil {
+		writeTagError(w, r, err, "Failed to tag product")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newTagResponse(tag))
+}
+
+func (s *Server) detachTagHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.DetachTag(r.Context(), r.PathValue("id"), r.PathValue("name")); err != nil {
+		writeTagError(w, r, err, "Failed to untag product")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listTagProductsHandler lists the products with a tag. It takes the same
+// filters, sorting and cursor as GET /products.
+func (s *Server) listTagProductsHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := s.db.GetTagByName(r.Context(), r.PathValue("name"))
+	if err != nil {
+		writeTagError(w, r, err, "Failed to list products")
+		return
+	}
+
+	filter, err := parseProductFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Tag = tag.Name
+
+	page, err := s.db.ListProducts(r.Context(), filter)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to list products", "error", err)
+		http.Error(w, "Failed to list products", http.StatusInternalServerError)
+		return
+	}
+
+	products, err := s.productResponses(r.Context(), page.Products)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("Failed to list products", "error", err)
+		http.Error(w, "Failed to list products", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, productPageResponse{Products: products, NextCursor: page.NextCursor})
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+)
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+type roleResponse struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+func (s *Server) setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var req roleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	role, err := database.ParseRole(req.Role)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := r.PathValue("id")
+	if err := s.db.SetUserRole(r.Context(), id, role); err != nil {
+		if errors.Is(err, database.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to set user role", "error", err)
+		http.Error(w, "Failed to set user role", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roleResponse{UserID: id, Role: string(role)})
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"kaffino/internal/database"
+	"kaffino/internal/logging"
+)
+
+type variantRequest struct {
+	Size  string  `json:"size"`
+	Price float64 `json:"price"`
+	Stock int64   `json:"stock"`
+}
+
+func (v variantRequest) valid() bool {
+	return v.Size != "" && v.Price >= 0 && v.Stock >= 0
+}
+
+func (s *Server) createVariantHandler(w http.ResponseWriter, r *http.Request) {
+	productID := r.PathValue("id")
+
+	var req variantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.valid() {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.db.GetProduct(r.Context(), productID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to get product", "error", err)
+		http.Error(w, "Failed to create variant", http.StatusInternalServerError)
+		return
+	}
+
+	variant := &database.Inventory{
+		ProductID: productID,
+		Sizes:     sql.NullString{String: req.Size, Valid: true},
+		Price:     req.Price,
+		Stock:     req.Stock,
+	}
+	if err := s.db.CreateVariant(r.Context(), variant); err != nil {
+		if errors.Is(err, database.ErrVariantExists) {
+			http.Error(w, "A variant of this size already exists", http.StatusConflict)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to create variant", "error", err)
+		http.Error(w, "Failed to create variant", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, variantResponse{ID: variant.ID, Size: req.Size, Price: variant.Price, Stock: variant.Stock})
+}
+
+func (s *Server) updateVariantHandler(w http.ResponseWriter, r *http.Request) {
+	var req variantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.valid() {
+		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		return
+	}
+
+	variant := &database.Inventory{
+		ID:        r.PathValue("variantId"),
+		ProductID: r.PathValue("id"),
+		Sizes:     sql.NullString{String: req.Size, Valid: true},
+		Price:     req.Price,
+		Stock:     req.Stock,
+	}
+	if err := s.db.UpdateVariant(r.Context(), variant); err != nil {
+		if errors.Is(err, database.ErrVariantNotFound) {
+			http.Error(w, "Variant not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, database.ErrVariantExists) {
+			http.Error(w, "A variant of this size already exists", http.StatusConflict)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to update variant", "error", err)
+		http.Error(w, "Failed to update variant", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, variantResponse{ID: variant.ID, Size: req.Size, Price: variant.Price, Stock: variant.Stock})
+}
+
+func (s *Server) deleteVariantHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.DeleteVariant(r.Context(), r.PathValue("id"), r.PathValue("variantId")); err != nil {
+		if errors.Is(err, database.ErrVariantNotFound) {
+			http.Error(w, "Variant not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to delete variant", "error", err)
+		http.Error(w, "Failed to delete variant", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
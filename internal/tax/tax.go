@@ -0,0 +1,38 @@
+// Package tax computes Peru's IGV (Impuesto General a las Ventas), the
+// sales tax charged on goods and on the delivery of orders. Tax is worked
+// out on each line of an order and rounded to the céntimo there, so the
+// order's tax is the sum of its lines, as on the receipts SUNAT expects.
+package tax
+
+import "math"
+
+// IGVRate is the general sales tax rate of Peru: 16% IGV plus 2% IPM.
+const IGVRate = 0.18
+
+// Rule says how tax applies to the prices of the catalog.
+type Rule struct {
+	// Rate is the tax rate, such as 0.18.
+	Rate float64
+	// Included is true when prices already include tax, as the prices
+	// shown to consumers in Peru must.
+	Included bool
+}
+
+// IGV is the rule for catalog prices that include IGV.
+var IGV = Rule{Rate: IGVRate, Included: true}
+
+// Split returns the taxable base of an amount charged under the rule and
+// the tax on it. With tax included the two add up to the amount; otherwise
+// the amount is the base and the tax comes on top.
+func (r Rule) Split(amount float64) (base, tax float64) {
+	if r.Included {
+		base = roundCents(amount / (1 + r.Rate))
+		return base, roundCents(amount - base)
+	}
+	return amount, roundCents(amount * r.Rate)
+}
+
+// roundCents rounds an amount to two decimal places.
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
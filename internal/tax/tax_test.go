@@ -0,0 +1,25 @@
+package tax
+
+import "testing"
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		rule      Rule
+		amount    float64
+		base, tax float64
+	}{
+		{IGV, 118, 100, 18},
+		{IGV, 15, 12.71, 2.29},
+		{IGV, 0.01, 0.01, 0},
+		{IGV, 0, 0, 0},
+		{Rule{Rate: IGVRate}, 100, 100, 18},
+		{Rule{Rate: IGVRate}, 12.5, 12.5, 2.25},
+		{Rule{Rate: 0, Included: true}, 20, 20, 0},
+	}
+	for _, tt := range tests {
+		base, tax := tt.rule.Split(tt.amount)
+		if base != tt.base || tax != tt.tax {
+			t.Errorf("%+v.Split(%v) = %v, %v; want %v, %v", tt.rule, tt.amount, base, tax, tt.base, tt.tax)
+		}
+	}
+}